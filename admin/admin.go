@@ -1,14 +1,137 @@
 package admin
 
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/connapotae/assessment-tax/tax"
+)
+
+// ActorKey is the key of the context value holding the name of the
+// authenticated admin.
+const ActorKey string = "admin.actor"
+
+const (
+	EntityDeduction    string = "deduction"
+	EntityExchangeRate string = "exchange_rate"
+	EntityTaxLevel     string = "tax_level"
+)
+
+// ErrDeductionNotFound is returned when a deduction to change has no
+// amount set in the store.
+var ErrDeductionNotFound = errors.New("deduction not found")
+
 type Err struct {
 	Message string `json:"message"`
 }
 
 type AdminDeduction struct {
-	Amount float64 `json:"amount"`
+	Amount        float64 `json:"amount"`
+	EffectiveFrom string  `json:"effectiveFrom"`
 }
 
 type DeductRes struct {
 	PersonalDeduction float64 `json:"personalDeduction,omitempty"`
 	KReceiptDeduction float64 `json:"kReceipt,omitempty"`
+	EffectiveFrom     string  `json:"effectiveFrom,omitempty"`
+}
+
+type AdminExchangeRate struct {
+	Rate float64 `json:"rate"`
+}
+
+type ExchangeRateRes struct {
+	Currency string  `json:"currency"`
+	Rate     float64 `json:"rate"`
+}
+
+// TaxLevel is a tax bracket as admins set it. The top bracket is
+// open-ended and has no MaxAmount.
+type TaxLevel struct {
+	Level      int      `json:"level"`
+	Label      string   `json:"label"`
+	LabelEn    string   `json:"labelEn"`
+	MinAmount  float64  `json:"minAmount"`
+	MaxAmount  *float64 `json:"maxAmount"`
+	TaxPercent int      `json:"taxPercent"`
+}
+
+type AdminTaxLevels struct {
+	Levels []TaxLevel `json:"levels"`
+}
+
+// TaxLevelPreview asks for the tax of the given net incomes under the
+// current and the proposed brackets.
+type TaxLevelPreview struct {
+	Levels  []TaxLevel `json:"levels"`
+	Incomes []float64  `json:"incomes"`
+}
+
+type TaxLevelSample struct {
+	NetIncome   float64 `json:"netIncome"`
+	CurrentTax  float64 `json:"currentTax"`
+	ProposedTax float64 `json:"proposedTax"`
+}
+
+type TaxLevelPreviewRes struct {
+	Levels  []TaxLevel       `json:"levels"`
+	Samples []TaxLevelSample `json:"samples"`
+}
+
+// TaxLevelsOf converts the brackets as stored, where the top bracket
+// ends at infinity.
+func TaxLevelsOf(levels []tax.TBTaxLevel) []TaxLevel {
+	res := make([]TaxLevel, len(levels))
+	for i, l := range levels {
+		res[i] = TaxLevel{Level: l.Level, Label: l.Label, LabelEn: l.LabelEn, MinAmount: l.MinAmount, TaxPercent: l.TaxPercent}
+		if !math.IsInf(l.MaxAmount, 1) {
+			max := l.MaxAmount
+			res[i].MaxAmount = &max
+		}
+	}
+	return res
+}
+
+func (l TaxLevel) record() tax.TBTaxLevel {
+	max := math.Inf(1)
+	if l.MaxAmount != nil {
+		max = *l.MaxAmount
+	}
+	return tax.TBTaxLevel{Level: l.Level, Label: l.Label, LabelEn: l.LabelEn, MinAmount: l.MinAmount, MaxAmount: max, TaxPercent: l.TaxPercent}
+}
+
+// Change tells who made a change to the configuration and in which
+// request, for the audit log.
+type Change struct {
+	Actor     string
+	RequestID string
+}
+
+// AuditEntry is one change to the configuration. OldValue is null when
+// the change created the value.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Entity    string          `json:"entity"`
+	Key       string          `json:"key"`
+	OldValue  json.RawMessage `json:"oldValue"`
+	NewValue  json.RawMessage `json:"newValue"`
+	Actor     string          `json:"actor"`
+	RequestID string          `json:"requestId"`
+	ChangedAt time.Time       `json:"changedAt"`
+}
+
+type AuditFilter struct {
+	Entity   string
+	Key      string
+	Page     int
+	PageSize int
+}
+
+type AuditPage struct {
+	Entries  []AuditEntry `json:"entries"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+	Total    int          `json:"total"`
 }
@@ -1,25 +1,65 @@
 package admin
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/connapotae/assessment-tax/dberr"
+	"github.com/connapotae/assessment-tax/i18n"
 	"github.com/connapotae/assessment-tax/tax"
 	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
 )
 
 type Handler struct {
-	store Storer
+	store       Storer
+	invalidator Invalidator
 }
 
 type Storer interface {
-	UpdateDeductionAmount(amount float64, types string) error
+	UpdateDeductionAmount(ctx context.Context, amount float64, types string, effectiveFrom time.Time, change Change) error
+	UpdateExchangeRate(ctx context.Context, currency string, rate float64, change Change) error
+	GetAuditLog(ctx context.Context, f AuditFilter) ([]AuditEntry, int, error)
+	GetTaxLevels(ctx context.Context) ([]tax.TBTaxLevel, error)
+	ReplaceTaxLevels(ctx context.Context, levels []tax.TBTaxLevel, change Change) error
+}
+
+// Invalidator is told when a change to the rules has committed, so that
+// copies of the rules kept elsewhere are read again.
+type Invalidator interface {
+	Invalidate()
 }
 
 func New(db Storer) *Handler {
 	return &Handler{store: db}
 }
 
+// WithInvalidator makes the handler tell i about every change it commits.
+func (h *Handler) WithInvalidator(i Invalidator) *Handler {
+	h.invalidator = i
+	return h
+}
+
+func (h *Handler) changed() {
+	if h.invalidator != nil {
+		h.invalidator.Invalidate()
+	}
+}
+
+// changeFrom identifies the admin and the request making a change.
+func changeFrom(c echo.Context) Change {
+	actor, _ := c.Get(ActorKey).(string)
+	requestID := c.Response().Header().Get(echo.HeaderXRequestID)
+	if requestID == "" {
+		requestID = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+	return Change{Actor: actor, RequestID: requestID}
+}
+
 type validates struct {
 	condition string
 	errString string
@@ -27,20 +67,21 @@ type validates struct {
 }
 
 func (h *Handler) SetupDeductionHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
 	var a AdminDeduction
 	if err := c.Bind(&a); err != nil {
-		return c.JSON(http.StatusBadRequest, tax.Err{Message: err.Error()})
+		return c.JSON(http.StatusBadRequest, tax.Err{Message: i18n.T(lang, i18n.InvalidRequest)})
 	}
 
 	m := map[string]validates{
-		"personal":  {condition: "required,gte=10000,lte=100000", errString: "amount must between 10,000 and 100,000", res: DeductRes{PersonalDeduction: a.Amount}},
-		"k-receipt": {condition: "required,gte=0,lte=100000", errString: "amount must between 0 and 100,000", res: DeductRes{KReceiptDeduction: a.Amount}},
+		"personal":  {condition: "required,gte=10000,lte=100000", errString: i18n.T(lang, i18n.PersonalDeductRange), res: DeductRes{PersonalDeduction: a.Amount}},
+		"k-receipt": {condition: "required,gte=0,lte=100000", errString: i18n.T(lang, i18n.KReceiptDeductRange), res: DeductRes{KReceiptDeduction: a.Amount}},
 	}
 
 	deductType := c.Param("deductType")
 
 	if _, ok := m[deductType]; !ok {
-		return c.JSON(http.StatusBadRequest, Err{Message: "deduct type not support"})
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.DeductTypeNotSupport)})
 	}
 
 	condition := m[deductType].condition
@@ -52,10 +93,113 @@ func (h *Handler) SetupDeductionHandler(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, Err{Message: errString})
 	}
 
-	if err := h.store.UpdateDeductionAmount(a.Amount, deductType); err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+	effectiveFrom, msg := parseEffectiveFrom(a.EffectiveFrom, lang)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, Err{Message: msg})
+	}
+
+	err := h.store.UpdateDeductionAmount(c.Request().Context(), a.Amount, deductType, effectiveFrom, changeFrom(c))
+	if errors.Is(err, ErrDeductionNotFound) {
+		return c.JSON(http.StatusNotFound, Err{Message: i18n.T(lang, i18n.DeductionNotFound)})
+	}
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	h.changed()
+
+	if a.EffectiveFrom != "" {
+		d := res.(DeductRes)
+		d.EffectiveFrom = effectiveFrom.Format(time.DateOnly)
+		res = d
 	}
 
 	return c.JSON(http.StatusCreated, res)
+}
+
+// parseEffectiveFrom reads the day a deduction change takes effect, today
+// when v is empty. A change cannot take effect before today, which would
+// change the result of calculations already made.
+func parseEffectiveFrom(v string, lang string) (time.Time, string) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if v == "" {
+		return today, ""
+	}
+	d, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+	if err != nil {
+		return time.Time{}, i18n.T(lang, i18n.InvalidEffectiveFrom)
+	}
+	if d.Before(today) {
+		return time.Time{}, i18n.T(lang, i18n.EffectiveFromPast)
+	}
+	return d, ""
+}
+
+func (h *Handler) SetupExchangeRateHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	var a AdminExchangeRate
+	if err := c.Bind(&a); err != nil {
+		return c.JSON(http.StatusBadRequest, tax.Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+
+	currency := strings.ToUpper(c.Param("currency"))
+
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Var(currency, "iso4217"); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.CurrencyCodeNotSupport)})
+	}
+	if err := validate.Var(a.Rate, "required,gt=0"); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.RateGtZero)})
+	}
+
+	if err := h.store.UpdateExchangeRate(c.Request().Context(), currency, a.Rate, changeFrom(c)); err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	h.changed()
+
+	return c.JSON(http.StatusCreated, ExchangeRateRes{Currency: currency, Rate: a.Rate})
+}
+
+const (
+	defaultPageSize int = 20
+	maxPageSize     int = 100
+)
+
+func positiveInt(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return n, nil
+}
+
+func (h *Handler) GetAuditLogHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	f := AuditFilter{Entity: c.QueryParam("entity"), Key: c.QueryParam("key")}
+	var err error
+	if f.Page, err = positiveInt(c.QueryParam("page"), 1); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+	if f.PageSize, err = positiveInt(c.QueryParam("pageSize"), defaultPageSize); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+	if f.PageSize > maxPageSize {
+		f.PageSize = maxPageSize
+	}
+
+	entries, total, err := h.store.GetAuditLog(c.Request().Context(), f)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
 
+	return c.JSON(http.StatusOK, AuditPage{Entries: entries, Page: f.Page, PageSize: f.PageSize, Total: total})
 }
@@ -1,21 +1,58 @@
 package admin
 
 import (
+	"context"
 	"encoding/json"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/connapotae/assessment-tax/tax"
 	"github.com/labstack/echo/v4"
 )
 
 type StubAdmin struct {
-	errs error
+	errs     error
+	changes  *[]Change
+	entries  []AuditEntry
+	filter   *AuditFilter
+	levels   []tax.TBTaxLevel
+	replaced *[]tax.TBTaxLevel
 }
 
-func (s StubAdmin) UpdateDeductionAmount(float64, string) error {
+func (s StubAdmin) UpdateDeductionAmount(ctx context.Context, amount float64, types string, effectiveFrom time.Time, change Change) error {
+	if s.changes != nil {
+		*s.changes = append(*s.changes, change)
+	}
+	return s.errs
+}
+
+func (s StubAdmin) UpdateExchangeRate(ctx context.Context, currency string, rate float64, change Change) error {
+	if s.changes != nil {
+		*s.changes = append(*s.changes, change)
+	}
+	return s.errs
+}
+
+func (s StubAdmin) GetAuditLog(ctx context.Context, f AuditFilter) ([]AuditEntry, int, error) {
+	if s.filter != nil {
+		*s.filter = f
+	}
+	return s.entries, len(s.entries), s.errs
+}
+
+func (s StubAdmin) GetTaxLevels(ctx context.Context) ([]tax.TBTaxLevel, error) {
+	return s.levels, s.errs
+}
+
+func (s StubAdmin) ReplaceTaxLevels(ctx context.Context, levels []tax.TBTaxLevel, change Change) error {
+	if s.replaced != nil {
+		*s.replaced = levels
+	}
 	return s.errs
 }
 
@@ -31,6 +68,7 @@ func TestAdmin(t *testing.T) {
 		{name: "given unable to setting personal deduction should return 400 and error message", deductType: "personal", req: `{ "amount": 9000.0 }`, stub: StubAdmin{}, want: http.StatusBadRequest},
 		{name: "given unable to setting personal deduction with wrong path should return 400 and error message", deductType: "", req: `{ "amount": 70000.0 }`, stub: StubAdmin{}, want: http.StatusBadRequest},
 		{name: "given unable to setting k-receipt deduction should return 500 and error message", deductType: "k-receipt", req: `{ "amount": 70000.0 }`, stub: StubAdmin{errs: echo.ErrInternalServerError}, want: http.StatusInternalServerError},
+		{name: "given deduction without an amount in the store should return 404 and error message", deductType: "k-receipt", req: `{ "amount": 70000.0 }`, stub: StubAdmin{errs: ErrDeductionNotFound}, want: http.StatusNotFound},
 		{name: "given unable to setting k-receipt deduction should return 400 and error message", deductType: "k-receipt", req: `{ "amount": 200000.0 }`, stub: StubAdmin{}, want: http.StatusBadRequest},
 		{name: "given unable to setting k-receipt deduction with wrong data type should return 400 and error message", deductType: "k-receipt", req: `{ "amount": "test" }`, stub: StubAdmin{}, want: http.StatusBadRequest},
 		{name: "given unable to setting k-receipt deduction with wrong path should return 400 and error message", deductType: "", req: `{ "amount": 70000.0 }`, stub: StubAdmin{}, want: http.StatusBadRequest},
@@ -102,4 +140,312 @@ func TestAdmin(t *testing.T) {
 			}
 		})
 	}
+
+	tests3 := []struct {
+		name     string
+		currency string
+		req      string
+		stub     StubAdmin
+		want     int
+	}{
+		{name: "given user able to setting exchange rate should return 201", currency: "usd", req: `{ "rate": 36.5 }`, stub: StubAdmin{}, want: http.StatusCreated},
+		{name: "given unable to setting exchange rate should return 500 and error message", currency: "USD", req: `{ "rate": 36.5 }`, stub: StubAdmin{errs: echo.ErrInternalServerError}, want: http.StatusInternalServerError},
+		{name: "given unable to setting exchange rate with unknown currency should return 400 and error message", currency: "XYZ", req: `{ "rate": 36.5 }`, stub: StubAdmin{}, want: http.StatusBadRequest},
+		{name: "given unable to setting exchange rate with zero rate should return 400 and error message", currency: "EUR", req: `{ "rate": 0 }`, stub: StubAdmin{}, want: http.StatusBadRequest},
+	}
+	for _, tt := range tests3 {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.req))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/admin/exchange-rates/:currency")
+			c.SetParamNames("currency")
+			c.SetParamValues(tt.currency)
+
+			p := New(tt.stub)
+			p.SetupExchangeRateHandler(c)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status code %d but got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestDeductionEffectiveFrom(t *testing.T) {
+	tomorrow := time.Now().AddDate(0, 0, 1).Format(time.DateOnly)
+	yesterday := time.Now().AddDate(0, 0, -1).Format(time.DateOnly)
+	tests := []struct {
+		name     string
+		req      string
+		wantCode int
+		want     DeductRes
+	}{
+		{name: "given effective from a future date should schedule the deduction", req: `{ "amount": 70000.0, "effectiveFrom": "` + tomorrow + `" }`, wantCode: http.StatusCreated, want: DeductRes{PersonalDeduction: 70000.0, EffectiveFrom: tomorrow}},
+		{name: "given effective from a past date should return 400", req: `{ "amount": 70000.0, "effectiveFrom": "` + yesterday + `" }`, wantCode: http.StatusBadRequest},
+		{name: "given effective from not a date should return 400", req: `{ "amount": 70000.0, "effectiveFrom": "01/01/2027" }`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.req))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/admin/deductions/:deductType")
+			c.SetParamNames("deductType")
+			c.SetParamValues("personal")
+
+			New(StubAdmin{}).SetupDeductionHandler(c)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected status code %d but got %d", tt.wantCode, rec.Code)
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+			var got DeductRes
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Errorf("unable to unmarshal json: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %v but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAuditChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		param   string
+		value   string
+		req     string
+		handler func(*Handler, echo.Context) error
+	}{
+		{name: "given admin setting deduction should pass actor and request id to store", path: "/admin/deductions/:deductType", param: "deductType", value: "personal", req: `{ "amount": 70000.0 }`, handler: (*Handler).SetupDeductionHandler},
+		{name: "given admin setting exchange rate should pass actor and request id to store", path: "/admin/exchange-rates/:currency", param: "currency", value: "USD", req: `{ "rate": 36.5 }`, handler: (*Handler).SetupExchangeRateHandler},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.req))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			rec.Header().Set(echo.HeaderXRequestID, "req-1")
+			c := e.NewContext(req, rec)
+			c.SetPath(tt.path)
+			c.SetParamNames(tt.param)
+			c.SetParamValues(tt.value)
+			c.Set(ActorKey, "adminTax")
+
+			var changes []Change
+			p := New(StubAdmin{changes: &changes})
+			tt.handler(p, c)
+
+			want := []Change{{Actor: "adminTax", RequestID: "req-1"}}
+			if !reflect.DeepEqual(changes, want) {
+				t.Errorf("expected %v but got %v", want, changes)
+			}
+		})
+	}
+}
+
+func TestGetAuditLog(t *testing.T) {
+	entry := AuditEntry{ID: 1, Entity: EntityDeduction, Key: "personal", OldValue: json.RawMessage("60000"), NewValue: json.RawMessage("70000"), Actor: "adminTax", RequestID: "req-1"}
+	tests := []struct {
+		name       string
+		query      string
+		stub       StubAdmin
+		wantCode   int
+		wantFilter AuditFilter
+	}{
+		{name: "given no query should return first page of audit log", query: "", stub: StubAdmin{entries: []AuditEntry{entry}}, wantCode: http.StatusOK, wantFilter: AuditFilter{Page: 1, PageSize: 20}},
+		{name: "given entity and key should filter audit log", query: "?entity=deduction&key=personal&page=2&pageSize=500", stub: StubAdmin{entries: []AuditEntry{entry}}, wantCode: http.StatusOK, wantFilter: AuditFilter{Entity: "deduction", Key: "personal", Page: 2, PageSize: 100}},
+		{name: "given invalid page should return 400", query: "?page=0", stub: StubAdmin{}, wantCode: http.StatusBadRequest},
+		{name: "given store error should return 500", query: "", stub: StubAdmin{errs: echo.ErrInternalServerError}, wantCode: http.StatusInternalServerError, wantFilter: AuditFilter{Page: 1, PageSize: 20}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/admin/audit"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			var filter AuditFilter
+			tt.stub.filter = &filter
+			p := New(tt.stub)
+			p.GetAuditLogHandler(c)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected status code %d but got %d", tt.wantCode, rec.Code)
+			}
+			if filter != tt.wantFilter {
+				t.Errorf("expected filter %v but got %v", tt.wantFilter, filter)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got AuditPage
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Errorf("unable to unmarshal json: %v", err)
+			}
+			if got.Total != 1 || len(got.Entries) != 1 || string(got.Entries[0].OldValue) != "60000" {
+				t.Errorf("expected one audit entry but got %+v", got)
+			}
+		})
+	}
+}
+
+func TestTaxLevels(t *testing.T) {
+	current := []tax.TBTaxLevel{
+		{Level: 1, Label: "0-150,000", MinAmount: 0, MaxAmount: 150000, TaxPercent: 0},
+		{Level: 2, Label: "150,001 ขึ้นไป", MinAmount: 150000, MaxAmount: math.Inf(1), TaxPercent: 10},
+	}
+
+	t.Run("given admin listing tax levels should return the top level without maxAmount", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/admin/tax-levels", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		New(StubAdmin{levels: current}).GetTaxLevelsHandler(c)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d", http.StatusOK, rec.Code)
+		}
+		want := `{"levels":[{"level":1,"label":"0-150,000","labelEn":"","minAmount":0,"maxAmount":150000,"taxPercent":0},{"level":2,"label":"150,001 ขึ้นไป","labelEn":"","minAmount":150000,"maxAmount":null,"taxPercent":10}]}`
+		if got := strings.TrimSpace(rec.Body.String()); got != want {
+			t.Errorf("expected %s but got %s", want, got)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		req        string
+		wantCode   int
+		wantFields []string
+	}{
+		{name: "given contiguous levels should replace every level", req: `{ "levels": [{ "label": "0-200,000", "minAmount": 0, "maxAmount": 200000, "taxPercent": 0 }, { "label": "200,001 ขึ้นไป", "minAmount": 200000, "maxAmount": null, "taxPercent": 20 }] }`, wantCode: http.StatusOK},
+		{name: "given no levels should return 400", req: `{ "levels": [] }`, wantCode: http.StatusBadRequest, wantFields: []string{"levels"}},
+		{name: "given first level not starting at zero should return 400", req: `{ "levels": [{ "label": "a", "minAmount": 100, "maxAmount": null, "taxPercent": 0 }] }`, wantCode: http.StatusBadRequest, wantFields: []string{"levels[0].minAmount"}},
+		{name: "given a gap between levels should return 400", req: `{ "levels": [{ "label": "a", "minAmount": 0, "maxAmount": 100, "taxPercent": 0 }, { "label": "b", "minAmount": 200, "maxAmount": null, "taxPercent": 10 }] }`, wantCode: http.StatusBadRequest, wantFields: []string{"levels[1].minAmount"}},
+		{name: "given overlapping or unsorted levels should return 400", req: `{ "levels": [{ "label": "a", "minAmount": 0, "maxAmount": 200, "taxPercent": 0 }, { "label": "b", "minAmount": 100, "maxAmount": null, "taxPercent": 10 }] }`, wantCode: http.StatusBadRequest, wantFields: []string{"levels[1].minAmount"}},
+		{name: "given top level with maxAmount should return 400", req: `{ "levels": [{ "label": "a", "minAmount": 0, "maxAmount": 200, "taxPercent": 0 }] }`, wantCode: http.StatusBadRequest, wantFields: []string{"levels[0].maxAmount"}},
+		{name: "given open-ended level below the top should return 400", req: `{ "levels": [{ "label": "a", "minAmount": 0, "maxAmount": null, "taxPercent": 0 }, { "label": "b", "minAmount": 0, "maxAmount": null, "taxPercent": 10 }] }`, wantCode: http.StatusBadRequest, wantFields: []string{"levels[0].maxAmount"}},
+		{name: "given maxAmount not above minAmount and rate out of range should return 400", req: `{ "levels": [{ "label": "", "minAmount": 0, "maxAmount": 0, "taxPercent": 101 }, { "label": "b", "minAmount": 0, "maxAmount": null, "taxPercent": -1 }] }`, wantCode: http.StatusBadRequest, wantFields: []string{"levels[0].label", "levels[0].taxPercent", "levels[0].maxAmount", "levels[1].taxPercent"}},
+		{name: "given thai label as long as the column should accept the label", req: `{ "levels": [{ "label": "กกกกกกกกกกกกกกกกกกกก", "minAmount": 0, "maxAmount": null, "taxPercent": 101 }] }`, wantCode: http.StatusBadRequest, wantFields: []string{"levels[0].taxPercent"}},
+		{name: "given labels longer than their columns should return 400", req: `{ "levels": [{ "label": "กกกกกกกกกกกกกกกกกกกกก", "labelEn": "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "minAmount": 0, "maxAmount": null, "taxPercent": 0 }] }`, wantCode: http.StatusBadRequest, wantFields: []string{"levels[0].label", "levels[0].labelEn"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPut, "/admin/tax-levels", strings.NewReader(tt.req))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			var replaced []tax.TBTaxLevel
+			New(StubAdmin{replaced: &replaced}).ReplaceTaxLevelsHandler(c)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected status code %d but got %d %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			if rec.Code == http.StatusOK {
+				want := []tax.TBTaxLevel{
+					{Level: 1, Label: "0-200,000", MinAmount: 0, MaxAmount: 200000, TaxPercent: 0},
+					{Level: 2, Label: "200,001 ขึ้นไป", MinAmount: 200000, MaxAmount: math.Inf(1), TaxPercent: 20},
+				}
+				if !reflect.DeepEqual(replaced, want) {
+					t.Errorf("expected %v but got %v", want, replaced)
+				}
+				return
+			}
+			if replaced != nil {
+				t.Errorf("expected invalid levels not to be stored")
+			}
+			var errs []tax.ValidateErr
+			if err := json.Unmarshal(rec.Body.Bytes(), &errs); err != nil {
+				t.Fatalf("unable to unmarshal json: %v", err)
+			}
+			var fields []string
+			for _, e := range errs {
+				fields = append(fields, e.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("expected errors on %v but got %v", tt.wantFields, fields)
+			}
+		})
+	}
+
+	t.Run("given proposed levels should preview the tax under current and proposed levels", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/admin/tax-levels/preview", strings.NewReader(`{ "levels": [{ "label": "0-200,000", "minAmount": 0, "maxAmount": 200000, "taxPercent": 0 }, { "label": "200,001 ขึ้นไป", "minAmount": 200000, "maxAmount": null, "taxPercent": 20 }] }`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		var replaced []tax.TBTaxLevel
+		New(StubAdmin{levels: current, replaced: &replaced}).PreviewTaxLevelsHandler(c)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status code %d but got %d %s", http.StatusOK, rec.Code, rec.Body.String())
+		}
+		if replaced != nil {
+			t.Errorf("expected preview not to store levels")
+		}
+		var got TaxLevelPreviewRes
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("unable to unmarshal json: %v", err)
+		}
+		want := []TaxLevelSample{
+			{NetIncome: 150000, CurrentTax: 0, ProposedTax: 0},
+			{NetIncome: 200000, CurrentTax: 5000, ProposedTax: 0},
+			{NetIncome: 400000, CurrentTax: 25000, ProposedTax: 40000},
+		}
+		if !reflect.DeepEqual(got.Samples, want) {
+			t.Errorf("expected %v but got %v", want, got.Samples)
+		}
+	})
+}
+
+type StubInvalidator struct {
+	calls int
+}
+
+func (s *StubInvalidator) Invalidate() {
+	s.calls++
+}
+
+func TestInvalidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		stub      StubAdmin
+		wantCalls int
+	}{
+		{name: "given admin change committed should invalidate the rules", stub: StubAdmin{}, wantCalls: 1},
+		{name: "given admin change failed should not invalidate the rules", stub: StubAdmin{errs: echo.ErrInternalServerError}, wantCalls: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "amount": 70000.0 }`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/admin/deductions/:deductType")
+			c.SetParamNames("deductType")
+			c.SetParamValues("personal")
+
+			i := &StubInvalidator{}
+			New(tt.stub).WithInvalidator(i).SetupDeductionHandler(c)
+
+			if i.calls != tt.wantCalls {
+				t.Errorf("expected %d invalidations but got %d", tt.wantCalls, i.calls)
+			}
+		})
+	}
 }
@@ -0,0 +1,173 @@
+package admin
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/connapotae/assessment-tax/dberr"
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/connapotae/assessment-tax/tax"
+	"github.com/labstack/echo/v4"
+)
+
+// maxLabelLen and maxLabelEnLen are the lengths of the label and label_en
+// columns, in characters.
+const (
+	maxLabelLen   int = 20
+	maxLabelEnLen int = 30
+)
+
+// validateTaxLevels checks that the brackets in the order given start at
+// zero, follow each other without gaps or overlaps and end with a single
+// open-ended bracket, and numbers them from 1.
+func validateTaxLevels(levels []TaxLevel, lang string) ([]TaxLevel, []tax.ValidateErr) {
+	if len(levels) == 0 {
+		return nil, []tax.ValidateErr{{Field: "levels", Message: i18n.T(lang, i18n.TaxLevelsRequired)}}
+	}
+
+	var errs []tax.ValidateErr
+	res := make([]TaxLevel, len(levels))
+	last := len(levels) - 1
+	for i, l := range levels {
+		field := fmt.Sprintf("levels[%d].", i)
+		l.Level = i + 1
+		l.Label = strings.TrimSpace(l.Label)
+		l.LabelEn = strings.TrimSpace(l.LabelEn)
+		res[i] = l
+
+		if l.Label == "" {
+			errs = append(errs, tax.ValidateErr{Field: field + "label", Message: i18n.T(lang, i18n.TaxLevelLabelRequired)})
+		}
+		if utf8.RuneCountInString(l.Label) > maxLabelLen {
+			errs = append(errs, tax.ValidateErr{Field: field + "label", Message: i18n.T(lang, i18n.TaxLevelLabelTooLong, maxLabelLen)})
+		}
+		if utf8.RuneCountInString(l.LabelEn) > maxLabelEnLen {
+			errs = append(errs, tax.ValidateErr{Field: field + "labelEn", Message: i18n.T(lang, i18n.TaxLevelLabelTooLong, maxLabelEnLen)})
+		}
+		if l.TaxPercent < 0 || l.TaxPercent > 100 {
+			errs = append(errs, tax.ValidateErr{Field: field + "taxPercent", Message: i18n.T(lang, i18n.TaxLevelRateRange)})
+		}
+
+		switch {
+		case i == 0 && l.MinAmount != 0:
+			errs = append(errs, tax.ValidateErr{Field: field + "minAmount", Message: i18n.T(lang, i18n.TaxLevelStartAtZero)})
+		case i > 0 && levels[i-1].MaxAmount != nil && l.MinAmount > *levels[i-1].MaxAmount:
+			errs = append(errs, tax.ValidateErr{Field: field + "minAmount", Message: i18n.T(lang, i18n.TaxLevelGap)})
+		case i > 0 && levels[i-1].MaxAmount != nil && l.MinAmount < *levels[i-1].MaxAmount:
+			errs = append(errs, tax.ValidateErr{Field: field + "minAmount", Message: i18n.T(lang, i18n.TaxLevelOverlap)})
+		}
+
+		switch {
+		case i == last && l.MaxAmount != nil:
+			errs = append(errs, tax.ValidateErr{Field: field + "maxAmount", Message: i18n.T(lang, i18n.TaxLevelOpenEndedTop)})
+		case i < last && l.MaxAmount == nil:
+			errs = append(errs, tax.ValidateErr{Field: field + "maxAmount", Message: i18n.T(lang, i18n.TaxLevelOnlyTopOpenEnded)})
+		case l.MaxAmount != nil && *l.MaxAmount <= l.MinAmount:
+			errs = append(errs, tax.ValidateErr{Field: field + "maxAmount", Message: i18n.T(lang, i18n.TaxLevelMaxAboveMin)})
+		}
+	}
+	return res, errs
+}
+
+func (h *Handler) GetTaxLevelsHandler(c echo.Context) error {
+	levels, err := h.store.GetTaxLevels(c.Request().Context())
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	return c.JSON(http.StatusOK, AdminTaxLevels{Levels: TaxLevelsOf(levels)})
+}
+
+// ReplaceTaxLevelsHandler replaces every bracket with the ones given. The
+// brackets are checked as a whole, so a set that is only consistent after
+// all of its changes can be applied at once.
+func (h *Handler) ReplaceTaxLevelsHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	var a AdminTaxLevels
+	if err := c.Bind(&a); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+
+	levels, errs := validateTaxLevels(a.Levels, lang)
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, errs)
+	}
+
+	records := make([]tax.TBTaxLevel, len(levels))
+	for i, l := range levels {
+		records[i] = l.record()
+	}
+	if err := h.store.ReplaceTaxLevels(c.Request().Context(), records, changeFrom(c)); err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	h.changed()
+
+	return c.JSON(http.StatusOK, AdminTaxLevels{Levels: levels})
+}
+
+// PreviewTaxLevelsHandler checks proposed brackets without saving them and
+// compares the tax they give with the current brackets. Without incomes
+// the boundaries of both sets of brackets are compared.
+func (h *Handler) PreviewTaxLevelsHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	var p TaxLevelPreview
+	if err := c.Bind(&p); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+
+	levels, errs := validateTaxLevels(p.Levels, lang)
+	for i, v := range p.Incomes {
+		if v < 0 {
+			errs = append(errs, tax.ValidateErr{Field: fmt.Sprintf("incomes[%d]", i), Message: i18n.T(lang, i18n.GtZero)})
+		}
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, errs)
+	}
+
+	current, err := h.store.GetTaxLevels(c.Request().Context())
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	proposed := make([]tax.TBTaxLevel, len(levels))
+	for i, l := range levels {
+		proposed[i] = l.record()
+	}
+
+	incomes := p.Incomes
+	if len(incomes) == 0 {
+		incomes = boundaries(current, proposed)
+	}
+	samples := make([]TaxLevelSample, len(incomes))
+	for i, v := range incomes {
+		samples[i] = TaxLevelSample{NetIncome: v, CurrentTax: tax.TaxOnIncome(current, v), ProposedTax: tax.TaxOnIncome(proposed, v)}
+	}
+
+	return c.JSON(http.StatusOK, TaxLevelPreviewRes{Levels: levels, Samples: samples})
+}
+
+// boundaries returns the finite upper bounds of the brackets in order and
+// twice the highest of them, to show the tax in the top brackets.
+func boundaries(sets ...[]tax.TBTaxLevel) []float64 {
+	seen := make(map[float64]bool)
+	var res []float64
+	for _, levels := range sets {
+		for _, l := range levels {
+			if l.MaxAmount > 0 && !math.IsInf(l.MaxAmount, 1) && !seen[l.MaxAmount] {
+				seen[l.MaxAmount] = true
+				res = append(res, l.MaxAmount)
+			}
+		}
+	}
+	sort.Float64s(res)
+	if len(res) > 0 {
+		res = append(res, res[len(res)-1]*2)
+	}
+	return res
+}
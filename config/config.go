@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,12 +14,38 @@ type IConfig interface {
 	Port() string
 	Db() string
 	Admin() IAdmin
+	JobWorkers() int
+	JobLease() time.Duration
+	JobMaxUpload() int64
+	AutoMigrate() bool
+	History() IHistory
+	RulesCacheTTL() time.Duration
+	RulesPollInterval() time.Duration
+	QueryTimeout() time.Duration
 }
 
 type config struct {
-	port  string
-	url   string
-	admin *admin
+	port         string
+	url          string
+	admin        *admin
+	jobWorkers   int
+	jobLease     int
+	jobMaxUpload int
+	autoMigrate  bool
+	history      *history
+	rulesTTL     int
+	rulesPoll    int
+	queryTimeout int
+}
+
+type IHistory interface {
+	Enabled() bool
+	Retention() time.Duration
+}
+
+type history struct {
+	enabled       bool
+	retentionDays int
 }
 
 type IAdmin interface {
@@ -30,11 +58,33 @@ type admin struct {
 	adminPassword string
 }
 
-func (c *config) Port() string  { return fmt.Sprintf(":%s", c.port) }
-func (c *config) Db() string    { return c.url }
-func (c *config) Admin() IAdmin { return c.admin }
-func (a *admin) User() string   { return a.adminUsername }
-func (a *admin) Pass() string   { return a.adminPassword }
+func (c *config) Port() string      { return fmt.Sprintf(":%s", c.port) }
+func (c *config) Db() string        { return c.url }
+func (c *config) Admin() IAdmin     { return c.admin }
+func (c *config) JobWorkers() int   { return c.jobWorkers }
+func (c *config) AutoMigrate() bool { return c.autoMigrate }
+func (c *config) History() IHistory { return c.history }
+func (c *config) JobLease() time.Duration {
+	return time.Duration(c.jobLease) * time.Second
+}
+func (c *config) JobMaxUpload() int64 {
+	return int64(c.jobMaxUpload) << 20
+}
+func (c *config) RulesCacheTTL() time.Duration {
+	return time.Duration(c.rulesTTL) * time.Second
+}
+func (c *config) RulesPollInterval() time.Duration {
+	return time.Duration(c.rulesPoll) * time.Second
+}
+func (c *config) QueryTimeout() time.Duration {
+	return time.Duration(c.queryTimeout) * time.Second
+}
+func (a *admin) User() string    { return a.adminUsername }
+func (a *admin) Pass() string    { return a.adminPassword }
+func (h *history) Enabled() bool { return h.enabled }
+func (h *history) Retention() time.Duration {
+	return time.Duration(h.retentionDays) * 24 * time.Hour
+}
 
 func LoadConfig() IConfig {
 	err := godotenv.Load()
@@ -49,5 +99,36 @@ func LoadConfig() IConfig {
 			adminUsername: os.Getenv("ADMIN_USERNAME"),
 			adminPassword: os.Getenv("ADMIN_PASSWORD"),
 		},
+		jobWorkers:   envInt("JOB_WORKERS", 4),
+		jobLease:     envInt("JOB_LEASE_SECONDS", 60),
+		jobMaxUpload: envInt("JOB_MAX_UPLOAD_MB", 50),
+		autoMigrate:  envBool("DB_AUTO_MIGRATE", true),
+		history: &history{
+			enabled:       envBool("HISTORY_ENABLED", false),
+			retentionDays: envInt("HISTORY_RETENTION_DAYS", 365),
+		},
+		rulesTTL:     envInt("RULES_CACHE_TTL_SECONDS", 60),
+		rulesPoll:    envInt("RULES_POLL_SECONDS", 30),
+		queryTimeout: envInt("DB_QUERY_TIMEOUT_SECONDS", 5),
+	}
+}
+
+// envInt reads a positive integer from the environment, falling back to
+// def when the variable is unset or invalid.
+func envInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+// envBool reads a boolean from the environment, falling back to def when
+// the variable is unset or invalid.
+func envBool(key string, def bool) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return def
 	}
+	return v
 }
@@ -0,0 +1,31 @@
+// Package dberr turns the errors of a store, or of any other step that
+// fails on the server, into responses that do not show their messages
+// to clients.
+package dberr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/labstack/echo/v4"
+)
+
+// Response returns the status code and message to answer a request whose
+// store call, or other internal step, failed with err. A query that ran out of time is answered
+// with 504 and one cancelled, because the client went away or the server
+// is shutting down, with 503. Any other error is answered with 500 and
+// logged, since its message is not shown.
+func Response(c echo.Context, err error) (int, string) {
+	lang := i18n.Lang(c)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, i18n.T(lang, i18n.DatabaseTimeout)
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, i18n.T(lang, i18n.RequestCanceled)
+	default:
+		c.Logger().Error(err)
+		return http.StatusInternalServerError, i18n.T(lang, i18n.InternalError)
+	}
+}
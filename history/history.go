@@ -0,0 +1,50 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	KindTax         string = "tax"
+	KindWithholding string = "withholding"
+	KindHousehold   string = "household"
+	KindCSV         string = "csv"
+)
+
+var ErrNotFound = errors.New("calculation not found")
+
+type Err struct {
+	Message string `json:"message"`
+}
+
+// Record is one calculation as it was requested and answered, with the
+// version of the rules it was computed with.
+type Record struct {
+	ID             int64           `json:"id"`
+	Kind           string          `json:"kind"`
+	TaxIDs         []string        `json:"taxIds"`
+	Input          json.RawMessage `json:"input"`
+	Result         json.RawMessage `json:"result"`
+	RulesetVersion string          `json:"rulesetVersion"`
+	Client         string          `json:"client"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+// Filter selects the records of a taxpayer and a period, one page at a
+// time. Zero values do not restrict the result.
+type Filter struct {
+	TaxID    string
+	From     time.Time
+	To       time.Time
+	Page     int
+	PageSize int
+}
+
+type Page struct {
+	Records  []Record `json:"records"`
+	Page     int      `json:"page"`
+	PageSize int      `json:"pageSize"`
+	Total    int      `json:"total"`
+}
@@ -0,0 +1,118 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/connapotae/assessment-tax/dberr"
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPageSize int    = 20
+	maxPageSize     int    = 100
+	dateLayout      string = "2006-01-02"
+)
+
+type Handler struct {
+	store Storer
+}
+
+type Storer interface {
+	ListCalculations(ctx context.Context, f Filter) ([]Record, int, error)
+	GetCalculation(ctx context.Context, id int64) (Record, error)
+}
+
+func New(db Storer) *Handler {
+	return &Handler{store: db}
+}
+
+// parseDate reads a date given either as a day or as a full timestamp.
+// A day given as the end of a range includes the whole of it.
+func parseDate(v string, end bool) (time.Time, error) {
+	if d, err := time.Parse(dateLayout, v); err == nil {
+		if end {
+			d = d.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return d, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func positiveInt(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return n, nil
+}
+
+func filterFrom(c echo.Context) (Filter, error) {
+	f := Filter{TaxID: c.QueryParam("taxId")}
+	var err error
+	if v := c.QueryParam("from"); v != "" {
+		if f.From, err = parseDate(v, false); err != nil {
+			return f, err
+		}
+	}
+	if v := c.QueryParam("to"); v != "" {
+		if f.To, err = parseDate(v, true); err != nil {
+			return f, err
+		}
+	}
+	if f.Page, err = positiveInt(c.QueryParam("page"), 1); err != nil {
+		return f, err
+	}
+	if f.PageSize, err = positiveInt(c.QueryParam("pageSize"), defaultPageSize); err != nil {
+		return f, err
+	}
+	if f.PageSize > maxPageSize {
+		f.PageSize = maxPageSize
+	}
+	return f, nil
+}
+
+func (h *Handler) ListHistoryHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	f, err := filterFrom(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+
+	records, total, err := h.store.ListCalculations(c.Request().Context(), f)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	if records == nil {
+		records = []Record{}
+	}
+
+	return c.JSON(http.StatusOK, Page{Records: records, Page: f.Page, PageSize: f.PageSize, Total: total})
+}
+
+func (h *Handler) GetHistoryHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, Err{Message: i18n.T(lang, i18n.CalculationNotFound)})
+	}
+
+	r, err := h.store.GetCalculation(c.Request().Context(), id)
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, Err{Message: i18n.T(lang, i18n.CalculationNotFound)})
+	}
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+
+	return c.JSON(http.StatusOK, r)
+}
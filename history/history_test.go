@@ -0,0 +1,123 @@
+package history
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type StubHistory struct {
+	records []Record
+	filter  Filter
+	err     error
+}
+
+func (s *StubHistory) ListCalculations(ctx context.Context, f Filter) ([]Record, int, error) {
+	s.filter = f
+	return s.records, len(s.records), s.err
+}
+
+func (s *StubHistory) GetCalculation(ctx context.Context, id int64) (Record, error) {
+	for _, r := range s.records {
+		if r.ID == id {
+			return r, s.err
+		}
+	}
+	return Record{}, ErrNotFound
+}
+
+func TestHistory(t *testing.T) {
+	record := Record{ID: 1, Kind: KindTax, TaxIDs: []string{"1101700230708"}, Input: json.RawMessage(`{"totalIncome":500000}`), Result: json.RawMessage(`{"tax":29000}`), RulesetVersion: "abc", Client: "10.0.0.1"}
+
+	t.Run("given user able to list history by tax id and dates should return page of records", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/?taxId=1101700230708&from=2024-01-01&to=2024-12-31&page=2&pageSize=500", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/admin/calculations/history")
+
+		store := &StubHistory{records: []Record{record}}
+		New(store).ListHistoryHandler(c)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status code %d but got %d", http.StatusOK, rec.Code)
+		}
+		want := Filter{
+			TaxID:    "1101700230708",
+			From:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:       time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Nanosecond),
+			Page:     2,
+			PageSize: maxPageSize,
+		}
+		if store.filter != want {
+			t.Errorf("expected filter %v but got %v", want, store.filter)
+		}
+		var got Page
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		if got.Total != 1 || got.Page != 2 || len(got.Records) != 1 || got.Records[0].RulesetVersion != "abc" {
+			t.Errorf("expected one record on page 2 but got %v", got)
+		}
+	})
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{name: "given invalid date should return 400", query: "?from=yesterday", want: http.StatusBadRequest},
+		{name: "given invalid page should return 400", query: "?page=0", want: http.StatusBadRequest},
+		{name: "given no filter should return 200", query: "", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/admin/calculations/history")
+
+			New(&StubHistory{}).ListHistoryHandler(c)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status code %d but got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	getTests := []struct {
+		name string
+		id   string
+		err  error
+		want int
+	}{
+		{name: "given user able to get history record should return 200", id: "1", want: http.StatusOK},
+		{name: "given unknown history record should return 404", id: "2", want: http.StatusNotFound},
+		{name: "given invalid history id should return 404", id: "abc", want: http.StatusNotFound},
+		{name: "given store error should return 500", id: "1", err: errors.New("db down"), want: http.StatusInternalServerError},
+	}
+	for _, tt := range getTests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/admin/calculations/history/:id")
+			c.SetParamNames("id")
+			c.SetParamValues(tt.id)
+
+			New(&StubHistory{records: []Record{record}, err: tt.err}).GetHistoryHandler(c)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status code %d but got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package history
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+type Purger interface {
+	PurgeCalculations(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Purge deletes the records older than retention every interval until
+// ctx is done.
+func Purge(ctx context.Context, db Purger, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := db.PurgeCalculations(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("purge calculation history: %v", err)
+		} else if n > 0 {
+			log.Printf("purged %d calculations from history", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package i18n
+
+import (
+	"fmt"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/text/language"
+)
+
+const (
+	TH string = "th"
+	EN string = "en"
+)
+
+const (
+	InvalidRequest           string = "invalidRequest"
+	InvalidDataFile          string = "invalidDataFile"
+	GtZero                   string = "gtZero"
+	LtTotalIncome            string = "ltTotalIncome"
+	LtForeignAmount          string = "ltForeignAmount"
+	LtAmountPaid             string = "ltAmountPaid"
+	CurrencyNotSupport       string = "currencyNotSupport"
+	InvalidTaxID             string = "invalidTaxId"
+	DuplicateOf              string = "duplicateOf"
+	NotEmpty                 string = "notEmpty"
+	IncomeTypeNotSupport     string = "incomeTypeNotSupport"
+	DeductTypeNotSupport     string = "deductTypeNotSupport"
+	PersonalDeductRange      string = "personalDeductRange"
+	KReceiptDeductRange      string = "kReceiptDeductRange"
+	RateGtZero               string = "rateGtZero"
+	NotNumber                string = "notNumber"
+	MissingColumn            string = "missingColumn"
+	EmptyFile                string = "emptyFile"
+	MalformedCSV             string = "malformedCsv"
+	MalformedXLSX            string = "malformedXlsx"
+	SheetNotFound            string = "sheetNotFound"
+	EncodingNotSupport       string = "encodingNotSupport"
+	DelimiterNotSupport      string = "delimiterNotSupport"
+	AboveCap                 string = "aboveCap"
+	WhtAboveRate             string = "whtAboveRate"
+	CalculationNotFound      string = "calculationNotFound"
+	ProfileNotFound          string = "profileNotFound"
+	ProfileTaxIDMismatch     string = "profileTaxIdMismatch"
+	ProfileExists            string = "profileExists"
+	InvalidTaxYear           string = "invalidTaxYear"
+	TaxYearMismatch          string = "taxYearMismatch"
+	InvalidEffectiveFrom     string = "invalidEffectiveFrom"
+	EffectiveFromPast        string = "effectiveFromPast"
+	TaxLevelsRequired        string = "taxLevelsRequired"
+	TaxLevelLabelRequired    string = "taxLevelLabelRequired"
+	TaxLevelLabelTooLong     string = "taxLevelLabelTooLong"
+	TaxLevelRateRange        string = "taxLevelRateRange"
+	TaxLevelStartAtZero      string = "taxLevelStartAtZero"
+	TaxLevelGap              string = "taxLevelGap"
+	TaxLevelOverlap          string = "taxLevelOverlap"
+	TaxLevelOpenEndedTop     string = "taxLevelOpenEndedTop"
+	TaxLevelOnlyTopOpenEnded string = "taxLevelOnlyTopOpenEnded"
+	TaxLevelMaxAboveMin      string = "taxLevelMaxAboveMin"
+	DatabaseTimeout          string = "databaseTimeout"
+	RequestCanceled          string = "requestCanceled"
+	InternalError            string = "internalError"
+	JobNotFound              string = "jobNotFound"
+	DeductionNotFound        string = "deductionNotFound"
+	JobNotFinished           string = "jobNotFinished"
+	JobFailed                string = "jobFailed"
+	JobFailedNoResult        string = "jobFailedNoResult"
+	FileTooLarge             string = "fileTooLarge"
+	CurrencyCodeNotSupport   string = "currencyCodeNotSupport"
+)
+
+var catalog = map[string]map[string]string{
+	TH: {
+		InvalidRequest:           "ข้อมูลที่ส่งมาไม่ถูกต้อง",
+		InvalidDataFile:          "ไฟล์มีข้อมูลไม่ถูกต้อง",
+		GtZero:                   "ต้องมีค่ามากกว่า 0",
+		LtTotalIncome:            "ต้องน้อยกว่ารายได้รวม",
+		LtForeignAmount:          "ต้องน้อยกว่ารายได้จากต่างประเทศ",
+		LtAmountPaid:             "ต้องน้อยกว่าจำนวนเงินที่จ่าย",
+		CurrencyNotSupport:       "ไม่รองรับสกุลเงิน %s",
+		InvalidTaxID:             "เลขประจำตัวผู้เสียภาษีไม่ถูกต้อง",
+		DuplicateOf:              "ซ้ำกับบรรทัดที่ %d",
+		NotEmpty:                 "ต้องไม่เป็นค่าว่าง",
+		IncomeTypeNotSupport:     "ไม่รองรับประเภทเงินได้นี้",
+		DeductTypeNotSupport:     "ไม่รองรับประเภทค่าลดหย่อนนี้",
+		PersonalDeductRange:      "จำนวนเงินต้องอยู่ระหว่าง 10,000 ถึง 100,000",
+		KReceiptDeductRange:      "จำนวนเงินต้องอยู่ระหว่าง 0 ถึง 100,000",
+		RateGtZero:               "อัตราแลกเปลี่ยนต้องมีค่ามากกว่า 0",
+		NotNumber:                "ต้องเป็นตัวเลข",
+		MissingColumn:            "ไม่พบคอลัมน์ %s",
+		EmptyFile:                "ไฟล์ไม่มีข้อมูล",
+		MalformedCSV:             "รูปแบบ CSV ไม่ถูกต้อง",
+		MalformedXLSX:            "รูปแบบ XLSX ไม่ถูกต้อง",
+		SheetNotFound:            "ไม่พบชีต %s",
+		EncodingNotSupport:       "ไม่รองรับการเข้ารหัส %s",
+		DelimiterNotSupport:      "ไม่รองรับตัวคั่น %q",
+		AboveCap:                 "เกินเพดานค่าลดหย่อน จะใช้ %s ในการคำนวณ",
+		WhtAboveRate:             "ภาษีหัก ณ ที่จ่ายมากกว่า %d%% ของรายได้รวม",
+		CalculationNotFound:      "ไม่พบประวัติการคำนวณนี้",
+		ProfileNotFound:          "ไม่พบข้อมูลผู้เสียภาษีนี้",
+		ProfileTaxIDMismatch:     "ไม่ตรงกับเลขประจำตัวผู้เสียภาษีของโปรไฟล์",
+		ProfileExists:            "มีโปรไฟล์ของเลขประจำตัวผู้เสียภาษีนี้แล้ว",
+		InvalidTaxYear:           "ปีภาษีไม่ถูกต้อง",
+		TaxYearMismatch:          "ปีภาษีของคู่สมรสต้องตรงกับผู้เสียภาษี",
+		InvalidEffectiveFrom:     "วันที่มีผลต้องอยู่ในรูปแบบ YYYY-MM-DD",
+		EffectiveFromPast:        "วันที่มีผลต้องไม่ก่อนวันนี้",
+		TaxLevelsRequired:        "ต้องมีขั้นเงินได้อย่างน้อยหนึ่งขั้น",
+		TaxLevelLabelRequired:    "ต้องระบุชื่อขั้นเงินได้",
+		TaxLevelLabelTooLong:     "ชื่อขั้นเงินได้ต้องยาวไม่เกิน %d ตัวอักษร",
+		TaxLevelRateRange:        "อัตราภาษีต้องอยู่ระหว่าง 0 ถึง 100",
+		TaxLevelStartAtZero:      "ขั้นเงินได้แรกต้องเริ่มที่ 0",
+		TaxLevelGap:              "ขั้นเงินได้ต้องเริ่มที่เงินได้สูงสุดของขั้นก่อนหน้า ไม่มีช่องว่าง",
+		TaxLevelOverlap:          "ขั้นเงินได้ต้องไม่ซ้อนทับกับขั้นก่อนหน้า",
+		TaxLevelOpenEndedTop:     "ขั้นเงินได้สุดท้ายต้องไม่มีเงินได้สูงสุด",
+		TaxLevelOnlyTopOpenEnded: "ต้องระบุเงินได้สูงสุด ยกเว้นขั้นสุดท้าย",
+		TaxLevelMaxAboveMin:      "เงินได้สูงสุดต้องมากกว่าเงินได้ต่ำสุด",
+		DatabaseTimeout:          "ฐานข้อมูลตอบกลับช้าเกินไป กรุณาลองใหม่อีกครั้ง",
+		RequestCanceled:          "คำขอถูกยกเลิก กรุณาลองใหม่อีกครั้ง",
+		InternalError:            "เกิดข้อผิดพลาดภายในระบบ",
+		JobNotFound:              "ไม่พบงานคำนวณนี้",
+		DeductionNotFound:        "ไม่พบค่าลดหย่อนนี้",
+		JobNotFinished:           "งานคำนวณยังไม่เสร็จ",
+		JobFailed:                "ไม่สามารถประมวลผลงานคำนวณนี้ได้ กรุณาลองใหม่อีกครั้ง",
+		JobFailedNoResult:        "งานคำนวณล้มเหลวและไม่มีผลลัพธ์: %s",
+		FileTooLarge:             "ไฟล์ต้องมีขนาดไม่เกิน %d MB",
+		CurrencyCodeNotSupport:   "ไม่รองรับสกุลเงินนี้",
+	},
+	EN: {
+		InvalidRequest:           "Request parameters are invalid.",
+		InvalidDataFile:          "File contains invalid data.",
+		GtZero:                   "must more than 0",
+		LtTotalIncome:            "must less than totalIncome",
+		LtForeignAmount:          "must less than foreign income amount",
+		LtAmountPaid:             "must less than amountPaid",
+		CurrencyNotSupport:       "currency %s not support",
+		InvalidTaxID:             "invalid tax id",
+		DuplicateOf:              "duplicate of line %d",
+		NotEmpty:                 "must not be empty",
+		IncomeTypeNotSupport:     "income type not support",
+		DeductTypeNotSupport:     "deduct type not support",
+		PersonalDeductRange:      "amount must between 10,000 and 100,000",
+		KReceiptDeductRange:      "amount must between 0 and 100,000",
+		RateGtZero:               "rate must more than 0",
+		NotNumber:                "must be a number",
+		MissingColumn:            "missing column %s",
+		EmptyFile:                "file is empty",
+		MalformedCSV:             "malformed csv",
+		MalformedXLSX:            "malformed xlsx",
+		SheetNotFound:            "sheet %s not found",
+		EncodingNotSupport:       "encoding %s not support",
+		DelimiterNotSupport:      "delimiter %q not support",
+		AboveCap:                 "above the deduction cap, %s will be used",
+		WhtAboveRate:             "wht is more than %d%% of totalIncome",
+		CalculationNotFound:      "calculation not found",
+		ProfileNotFound:          "profile not found",
+		ProfileTaxIDMismatch:     "does not match the tax id of the profile",
+		ProfileExists:            "profile of this tax id already exists",
+		InvalidTaxYear:           "invalid tax year",
+		TaxYearMismatch:          "tax year of spouse must match the taxpayer",
+		InvalidEffectiveFrom:     "effectiveFrom must be a date in the form YYYY-MM-DD",
+		EffectiveFromPast:        "effectiveFrom must not be before today",
+		TaxLevelsRequired:        "at least one tax level is required",
+		TaxLevelLabelRequired:    "label is required",
+		TaxLevelLabelTooLong:     "label must be at most %d characters",
+		TaxLevelRateRange:        "taxPercent must be between 0 and 100",
+		TaxLevelStartAtZero:      "the first level must start at 0",
+		TaxLevelGap:              "must start at the maxAmount of the previous level, leaving no gap",
+		TaxLevelOverlap:          "must not overlap the previous level",
+		TaxLevelOpenEndedTop:     "the top level must have no maxAmount",
+		TaxLevelOnlyTopOpenEnded: "maxAmount is required on every level but the top",
+		TaxLevelMaxAboveMin:      "maxAmount must be greater than minAmount",
+		DatabaseTimeout:          "the database took too long to answer, please try again",
+		RequestCanceled:          "the request was cancelled, please try again",
+		InternalError:            "internal server error",
+		JobNotFound:              "job not found",
+		DeductionNotFound:        "deduction not found",
+		JobNotFinished:           "job is not finished yet",
+		JobFailed:                "the job could not be processed, please try again",
+		JobFailedNoResult:        "job failed and has no results: %s",
+		FileTooLarge:             "the file must not be larger than %d MB",
+		CurrencyCodeNotSupport:   "currency not support",
+	},
+}
+
+var matcher = language.NewMatcher([]language.Tag{language.English, language.Thai})
+
+// Lang picks the language of the response from the Accept-Language header
+// of the request. English is used when the header is missing or matches
+// neither catalog, as it was before Thai was offered.
+func Lang(c echo.Context) string {
+	tag, _ := language.MatchStrings(matcher, c.Request().Header.Get("Accept-Language"))
+	base, _ := tag.Base()
+	if base.String() == TH {
+		return TH
+	}
+	return EN
+}
+
+// T returns the message of key in lang formatted with args. A key missing
+// from lang falls back to English, the default language.
+func T(lang string, key string, args ...any) string {
+	msg, ok := catalog[lang][key]
+	if !ok {
+		msg = catalog[EN][key]
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
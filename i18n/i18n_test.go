@@ -0,0 +1,28 @@
+package i18n
+
+import "testing"
+
+func TestT(t *testing.T) {
+	catalog[EN]["OnlyInEnglish"] = "only in english"
+	defer delete(catalog[EN], "OnlyInEnglish")
+
+	tests := []struct {
+		name string
+		lang string
+		key  string
+		args []any
+		want string
+	}{
+		{name: "given key in lang should return its message", lang: TH, key: JobNotFound, want: catalog[TH][JobNotFound]},
+		{name: "given key missing from lang should fall back to english", lang: TH, key: "OnlyInEnglish", want: "only in english"},
+		{name: "given unknown lang should fall back to english", lang: "fr", key: JobNotFound, want: "job not found"},
+		{name: "given args should format the message", lang: EN, key: FileTooLarge, args: []any{50}, want: "the file must not be larger than 50 MB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := T(tt.lang, tt.key, tt.args...); got != tt.want {
+				t.Errorf("expected %s but got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package job
+
+import (
+	"errors"
+	"time"
+
+	"github.com/connapotae/assessment-tax/tax"
+)
+
+const (
+	StatusQueued  string = "queued"
+	StatusRunning string = "running"
+	StatusDone    string = "done"
+	StatusFailed  string = "failed"
+)
+
+var ErrNotFound = errors.New("job not found")
+
+// ErrNotClaimed is returned when a job is finished or leased to another
+// pool, so that the caller must leave it alone.
+var ErrNotClaimed = errors.New("job is finished or run by another worker")
+
+type Job struct {
+	ID        string      `json:"id"`
+	Status    string      `json:"status"`
+	Options   tax.Options `json:"-"`
+	Total     int         `json:"total"`
+	Processed int         `json:"processed"`
+	Failed    int         `json:"failed"`
+	Progress  float64     `json:"progress"`
+	Error     string      `json:"error,omitempty"`
+	CreatedAt time.Time   `json:"createdAt"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+}
+
+// withProgress fills in the share of the rows that are processed, in
+// percent.
+func (j Job) withProgress() Job {
+	j.Progress = 100
+	if j.Total > 0 {
+		j.Progress = float64(j.Processed) * 100 / float64(j.Total)
+	}
+	return j
+}
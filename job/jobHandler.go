@@ -0,0 +1,246 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/connapotae/assessment-tax/dberr"
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/connapotae/assessment-tax/tax"
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	store     Storer
+	calc      Calculator
+	queue     Queue
+	maxUpload int64
+}
+
+type Storer interface {
+	CreateJob(ctx context.Context, j Job, input []byte) error
+	GetJob(ctx context.Context, id string) (Job, error)
+	GetJobInput(ctx context.Context, id string) ([]byte, error)
+	ClaimJob(ctx context.Context, id string, owner string, lease time.Duration) (Job, error)
+	ClaimPendingJobs(ctx context.Context, owner string, lease time.Duration) ([]Job, error)
+	RenewJobLease(ctx context.Context, id string, owner string) error
+	SaveJobProgress(ctx context.Context, id string, owner string, processed int, failed int, results []tax.RowResult) error
+	UpdateJobStatus(ctx context.Context, id string, owner string, status string, errMsg string) error
+	EachJobResult(ctx context.Context, id string, fn func(tax.RowResult) error) error
+}
+
+// Calculator computes the rows of an uploaded file.
+type Calculator interface {
+	InspectCSV(ctx context.Context, f tax.File, opts tax.Options) (int, error)
+	ProcessCSV(ctx context.Context, f tax.File, opts tax.Options, skip int, fn func(tax.RowResult) error) error
+}
+
+type Queue interface {
+	Enqueue(id string)
+}
+
+func New(db Storer, calc Calculator, queue Queue) *Handler {
+	return &Handler{store: db, calc: calc, queue: queue}
+}
+
+// WithMaxUpload rejects the uploads larger than n bytes with 413.
+func (h *Handler) WithMaxUpload(n int64) *Handler {
+	h.maxUpload = n
+	return h
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (h *Handler) CreateJobHandler(c echo.Context) error {
+	opts := tax.OptionsFrom(c)
+	// keep what is needed to download the results as a spreadsheet
+	opts.Detailed = true
+	if h.maxUpload > 0 {
+		c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, h.maxUpload)
+	}
+	file, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, tax.Err{Message: i18n.T(opts.Lang, i18n.FileTooLarge, h.maxUpload>>20)})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, tax.Err{Message: i18n.T(opts.Lang, i18n.InvalidRequest)})
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+
+	total, err := h.calc.InspectCSV(c.Request().Context(), bytes.NewReader(data), opts)
+	var fe *tax.FileError
+	if errors.As(err, &fe) {
+		return c.JSON(http.StatusBadRequest, tax.ValidateCSVErr{Message: i18n.T(opts.Lang, i18n.InvalidDataFile), Data: fe.Errs})
+	}
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+
+	id, err := newID()
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+
+	j := Job{ID: id, Status: StatusQueued, Options: opts, Total: total}
+	if err := h.store.CreateJob(c.Request().Context(), j, data); err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+	h.queue.Enqueue(id)
+
+	c.Response().Header().Set(echo.HeaderLocation, c.Request().URL.Path+"/"+id)
+	return c.JSON(http.StatusAccepted, j.withProgress())
+}
+
+func (h *Handler) GetJobHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	j, err := h.store.GetJob(c.Request().Context(), c.Param("id"))
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, tax.Err{Message: i18n.T(lang, i18n.JobNotFound)})
+	}
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+
+	return c.JSON(http.StatusOK, j.withProgress())
+}
+
+func (h *Handler) GetJobResultHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	j, err := h.store.GetJob(c.Request().Context(), c.Param("id"))
+	if errors.Is(err, ErrNotFound) {
+		return c.JSON(http.StatusNotFound, tax.Err{Message: i18n.T(lang, i18n.JobNotFound)})
+	}
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+	if j.Status == StatusFailed {
+		return c.JSON(http.StatusUnprocessableEntity, tax.Err{Message: i18n.T(lang, i18n.JobFailedNoResult, j.Error)})
+	}
+	if j.Status != StatusDone {
+		return c.JSON(http.StatusConflict, tax.Err{Message: i18n.T(lang, i18n.JobNotFinished)})
+	}
+
+	if format := tax.TableFormat(c); format != "" {
+		return h.writeTable(c, format, j)
+	}
+
+	// the results are written as they are read, so the response only
+	// starts once the first one is read or none are found
+	var s *tax.ResultStream
+	begin := func() {
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", j.ID+".json"))
+		s = tax.NewResultStream(c, "results")
+	}
+	var summary tax.BatchSummary
+	err = h.store.EachJobResult(c.Request().Context(), j.ID, func(r tax.RowResult) error {
+		if s == nil {
+			begin()
+		}
+		summary.Add(r)
+		return s.Write(r)
+	})
+	if err != nil && s == nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		begin()
+	}
+	return s.Close(tax.Field{Key: "id", Value: j.ID}, tax.Field{Key: "summary", Value: summary})
+}
+
+// writeTable renders the results of a job as a spreadsheet with the
+// columns of the file it was created from. The columns of the levels are
+// named after the first calculated row, so the failed rows before it are
+// held until it is read.
+func (h *Handler) writeTable(c echo.Context, format string, j Job) error {
+	input, err := h.store.GetJobInput(c.Request().Context(), j.ID)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+	header, err := tax.ReadHeader(bytes.NewReader(input), j.Options)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+
+	var t *tax.Table
+	var held []tax.RowResult
+	begin := func(levels []string) error {
+		var err error
+		if t, err = tax.NewTable(c, format, j.ID, header, levels, true); err != nil {
+			return err
+		}
+		for _, r := range held {
+			if err := t.Write(r); err != nil {
+				return err
+			}
+		}
+		held = nil
+		return nil
+	}
+	err = h.store.EachJobResult(c.Request().Context(), j.ID, func(r tax.RowResult) error {
+		if t == nil && r.Result == nil {
+			held = append(held, r)
+			return nil
+		}
+		if t == nil {
+			var levels []string
+			for _, l := range r.Result.TaxLevel {
+				levels = append(levels, l.Level)
+			}
+			if err := begin(levels); err != nil {
+				return err
+			}
+		}
+		return t.Write(r)
+	})
+	if err != nil && t == nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, tax.Err{Message: msg})
+	}
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		if err := begin(nil); err != nil {
+			return err
+		}
+	}
+	return t.Close()
+}
@@ -0,0 +1,464 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/connapotae/assessment-tax/tax"
+	"github.com/labstack/echo/v4"
+)
+
+type StubJob struct {
+	mu      sync.Mutex
+	jobs    map[string]Job
+	inputs  map[string][]byte
+	results map[string][]tax.RowResult
+	owners  map[string]string
+	beats   map[string]time.Time
+	err     error
+}
+
+func newStubJob() *StubJob {
+	return &StubJob{jobs: map[string]Job{}, inputs: map[string][]byte{}, results: map[string][]tax.RowResult{}, owners: map[string]string{}, beats: map[string]time.Time{}}
+}
+
+func (s *StubJob) CreateJob(ctx context.Context, j Job, input []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[j.ID] = j
+	s.inputs[j.ID] = input
+	return s.err
+}
+
+func (s *StubJob) GetJob(ctx context.Context, id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return j, ErrNotFound
+	}
+	return j, s.err
+}
+
+func (s *StubJob) GetJobInput(ctx context.Context, id string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inputs[id], s.err
+}
+
+// claim leases a job to owner the way the store does; s.mu must be held.
+func (s *StubJob) claim(id string, owner string, lease time.Duration) bool {
+	j, ok := s.jobs[id]
+	if !ok {
+		return false
+	}
+	expired := time.Since(s.beats[id]) > lease
+	if j.Status != StatusQueued && !(j.Status == StatusRunning && (s.owners[id] == owner || expired)) {
+		return false
+	}
+	j.Status = StatusRunning
+	s.jobs[id] = j
+	s.owners[id] = owner
+	s.beats[id] = time.Now()
+	return true
+}
+
+func (s *StubJob) ClaimJob(ctx context.Context, id string, owner string, lease time.Duration) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.claim(id, owner, lease) {
+		return Job{}, ErrNotClaimed
+	}
+	return s.jobs[id], s.err
+}
+
+func (s *StubJob) ClaimPendingJobs(ctx context.Context, owner string, lease time.Duration) ([]Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var jobs []Job
+	for id := range s.jobs {
+		if s.claim(id, owner, lease) {
+			jobs = append(jobs, s.jobs[id])
+		}
+	}
+	return jobs, s.err
+}
+
+func (s *StubJob) RenewJobLease(ctx context.Context, id string, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owners[id] != owner {
+		return ErrNotClaimed
+	}
+	s.beats[id] = time.Now()
+	return s.err
+}
+
+func (s *StubJob) SaveJobProgress(ctx context.Context, id string, owner string, processed int, failed int, results []tax.RowResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owners[id] != owner {
+		return ErrNotClaimed
+	}
+	j := s.jobs[id]
+	j.Processed, j.Failed = processed, failed
+	s.jobs[id] = j
+	s.results[id] = append(s.results[id], results...)
+	return s.err
+}
+
+func (s *StubJob) UpdateJobStatus(ctx context.Context, id string, owner string, status string, errMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owners[id] != owner {
+		return ErrNotClaimed
+	}
+	j := s.jobs[id]
+	j.Status, j.Error = status, errMsg
+	s.jobs[id] = j
+	delete(s.owners, id)
+	delete(s.beats, id)
+	return s.err
+}
+
+func (s *StubJob) EachJobResult(ctx context.Context, id string, fn func(tax.RowResult) error) error {
+	s.mu.Lock()
+	results := s.results[id]
+	s.mu.Unlock()
+	for _, r := range results {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+// StubCalc turns every line of the input after the header into a result,
+// then returns err. When block is set it waits for block to be closed
+// before the first row.
+type StubCalc struct {
+	skipped []int
+	block   chan struct{}
+	err     error
+}
+
+func (s *StubCalc) InspectCSV(ctx context.Context, f tax.File, opts tax.Options) (int, error) {
+	b, _ := io.ReadAll(f)
+	return strings.Count(string(b), "\n") - 1, s.err
+}
+
+func (s *StubCalc) ProcessCSV(ctx context.Context, f tax.File, opts tax.Options, skip int, fn func(tax.RowResult) error) error {
+	s.skipped = append(s.skipped, skip)
+	if s.block != nil {
+		select {
+		case <-s.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	b, _ := io.ReadAll(f)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	for i := 1 + skip; i < len(lines); i++ {
+		if err := fn(tax.RowResult{Line: i + 1, Status: "ok", Result: &tax.TaxesDetail{}}); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+type StubQueue struct {
+	ids []string
+}
+
+func (s *StubQueue) Enqueue(id string) {
+	s.ids = append(s.ids, id)
+}
+
+func TestJob(t *testing.T) {
+	upload := func(csv string) (*http.Request, *httptest.ResponseRecorder) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "file.csv")
+		io.Copy(part, strings.NewReader(csv))
+		writer.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/tax/calculations/jobs", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		return req, httptest.NewRecorder()
+	}
+
+	t.Run("given user able to create calculation job should return 202 and queue the job", func(t *testing.T) {
+		store, queue := newStubJob(), &StubQueue{}
+		req, rec := upload("totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n")
+		c := echo.New().NewContext(req, rec)
+
+		New(store, &StubCalc{}, queue).CreateJobHandler(c)
+
+		if rec.Code != http.StatusAccepted {
+			t.Errorf("expected status code %d but got %d", http.StatusAccepted, rec.Code)
+		}
+		if len(queue.ids) != 1 || store.jobs[queue.ids[0]].Total != 2 {
+			t.Errorf("expected one queued job of 2 rows but got %v %v", queue.ids, store.jobs)
+		}
+	})
+
+	t.Run("given unable to create calculation job with invalid file should return 400 and error message", func(t *testing.T) {
+		req, rec := upload("wht\n0\n")
+		c := echo.New().NewContext(req, rec)
+
+		New(newStubJob(), &StubCalc{err: &tax.FileError{Errs: []tax.ValidateErr{{Message: "missing column totalIncome"}}}}, &StubQueue{}).CreateJobHandler(c)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		status string
+		path   string
+		want   int
+	}{
+		{name: "given user able to get job status should return 200", status: StatusRunning, path: "", want: http.StatusOK},
+		{name: "given unable to get result of unfinished job should return 409 and error message", status: StatusRunning, path: "/result", want: http.StatusConflict},
+		{name: "given user able to get result of finished job should return 200", status: StatusDone, path: "/result", want: http.StatusOK},
+		{name: "given unable to get result of failed job should return 422 and error message", status: StatusFailed, path: "/result", want: http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStubJob()
+			store.jobs["abc"] = Job{ID: "abc", Status: tt.status}
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			c.SetPath("/tax/calculations/jobs/:id" + tt.path)
+			c.SetParamNames("id")
+			c.SetParamValues("abc")
+
+			h := New(store, &StubCalc{}, &StubQueue{})
+			if tt.path == "" {
+				h.GetJobHandler(c)
+			} else {
+				h.GetJobResultHandler(c)
+			}
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status code %d but got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	t.Run("given user able to get result of finished job should stream results with id and summary", func(t *testing.T) {
+		store := newStubJob()
+		store.jobs["abc"] = Job{ID: "abc", Status: StatusDone}
+		store.results["abc"] = []tax.RowResult{{Line: 2, Status: "ok", Result: &tax.TaxesDetail{Tax: 29000.0}}, {Line: 3, Status: "failed"}}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("abc")
+
+		New(store, &StubCalc{}, &StubQueue{}).GetJobResultHandler(c)
+
+		var got struct {
+			ID      string           `json:"id"`
+			Results []tax.RowResult  `json:"results"`
+			Summary tax.BatchSummary `json:"summary"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("unable to unmarshal json: %v %s", err, rec.Body.String())
+		}
+		want := tax.BatchSummary{Total: 2, Succeeded: 1, Failed: 1}
+		if rec.Code != http.StatusOK || got.ID != "abc" || len(got.Results) != 2 || got.Summary != want {
+			t.Errorf("expected 2 results with summary %v but got %d %+v", want, rec.Code, got)
+		}
+	})
+
+	t.Run("given job input that cannot be read back should return 500 without the error", func(t *testing.T) {
+		store := newStubJob()
+		store.jobs["abc"] = Job{ID: "abc", Status: StatusDone}
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(echo.HeaderAccept, "text/csv")
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("abc")
+
+		New(store, &StubCalc{}, &StubQueue{}).GetJobResultHandler(c)
+
+		var got tax.Err
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		if rec.Code != http.StatusInternalServerError || got.Message != "internal server error" {
+			t.Errorf("expected status code %d and generic message but got %d %q", http.StatusInternalServerError, rec.Code, got.Message)
+		}
+	})
+
+	t.Run("given upload larger than the limit should return 413 and error message", func(t *testing.T) {
+		store, queue := newStubJob(), &StubQueue{}
+		req, rec := upload("totalIncome,wht,donation\n" + strings.Repeat("500000,0,0\n", 100))
+		c := echo.New().NewContext(req, rec)
+
+		New(store, &StubCalc{}, queue).WithMaxUpload(512).CreateJobHandler(c)
+
+		if rec.Code != http.StatusRequestEntityTooLarge || len(queue.ids) != 0 {
+			t.Errorf("expected status code %d and no job but got %d %v", http.StatusRequestEntityTooLarge, rec.Code, queue.ids)
+		}
+	})
+
+	t.Run("given unknown job should return 404 and error message", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("missing")
+
+		New(newStubJob(), &StubCalc{}, &StubQueue{}).GetJobHandler(c)
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("expected status code %d but got %d", http.StatusNotFound, rec.Code)
+		}
+	})
+
+	t.Run("given pending jobs on start should resume from the checkpoint and finish them", func(t *testing.T) {
+		store, calc := newStubJob(), &StubCalc{}
+		store.jobs["abc"] = Job{ID: "abc", Status: StatusRunning, Total: 3, Processed: 1}
+		store.inputs["abc"] = []byte("totalIncome\n1\n2\n3\n")
+		store.results["abc"] = []tax.RowResult{{Line: 2, Status: "ok"}}
+
+		p, err := NewPool(store, calc, 2, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Resume(); err != nil {
+			t.Fatal(err)
+		}
+		p.Start()
+		for i := 0; i < 100; i++ {
+			if j, _ := store.GetJob(context.Background(), "abc"); j.Status == StatusDone {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err := p.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		j := store.jobs["abc"]
+		if j.Status != StatusDone || j.Processed != 3 || len(store.results["abc"]) != 3 {
+			t.Errorf("expected done job with 3 results but got %v %v", j, store.results["abc"])
+		}
+		if len(calc.skipped) != 1 || calc.skipped[0] != 1 {
+			t.Errorf("expected job to skip 1 processed row but got %v", calc.skipped)
+		}
+	})
+
+	t.Run("given a job leased to another live pool should leave it alone", func(t *testing.T) {
+		store, calc := newStubJob(), &StubCalc{}
+		store.jobs["abc"] = Job{ID: "abc", Status: StatusRunning, Total: 3, Processed: 1}
+		store.inputs["abc"] = []byte("totalIncome\n1\n2\n3\n")
+		store.owners["abc"] = "other"
+		store.beats["abc"] = time.Now()
+
+		p, err := NewPool(store, calc, 2, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Resume(); err != nil {
+			t.Fatal(err)
+		}
+		p.Start()
+		p.Enqueue("abc")
+		time.Sleep(50 * time.Millisecond)
+		if err := p.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(calc.skipped) != 0 || store.owners["abc"] != "other" || store.jobs["abc"].Status != StatusRunning {
+			t.Errorf("expected job to stay with the other pool but got %v %v %v", calc.skipped, store.owners["abc"], store.jobs["abc"])
+		}
+	})
+
+	t.Run("given a job whose lease is lost while running should stop without saving it", func(t *testing.T) {
+		store := newStubJob()
+		store.jobs["abc"] = Job{ID: "abc", Status: StatusQueued, Total: 3}
+		store.inputs["abc"] = []byte("totalIncome\n1\n2\n3\n")
+
+		taken := make(chan struct{})
+		calc := &StubCalc{block: taken}
+		p, err := NewPool(store, calc, 1, 30*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Start()
+		p.Enqueue("abc")
+		for i := 0; i < 100; i++ {
+			if j, _ := store.GetJob(context.Background(), "abc"); j.Status == StatusRunning {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		// another pool takes the job over and keeps renewing it
+		store.mu.Lock()
+		store.owners["abc"] = "other"
+		store.beats["abc"] = time.Now().Add(time.Hour)
+		store.mu.Unlock()
+		close(taken)
+		time.Sleep(100 * time.Millisecond)
+		if err := p.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if j := store.jobs["abc"]; j.Status != StatusRunning || len(store.results["abc"]) != 0 {
+			t.Errorf("expected job left to the other pool but got %v %v", j, store.results["abc"])
+		}
+	})
+
+	failures := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "given a job whose file is invalid should fail with the errors of the file", err: &tax.FileError{Errs: []tax.ValidateErr{{Message: "missing column totalIncome"}}}, want: "missing column totalIncome"},
+		{name: "given a job that fails in the store should fail without showing the error", err: errors.New(`pq: relation "calculation_job_result" does not exist`), want: "the job could not be processed, please try again"},
+	}
+	for _, tt := range failures {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStubJob()
+			store.jobs["abc"] = Job{ID: "abc", Status: StatusQueued, Total: 1, Options: tax.Options{Lang: "en"}}
+			store.inputs["abc"] = []byte("totalIncome\n1\n")
+
+			p, err := NewPool(store, &StubCalc{err: tt.err}, 1, time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Start()
+			p.Enqueue("abc")
+			for i := 0; i < 100; i++ {
+				if j, _ := store.GetJob(context.Background(), "abc"); j.Status == StatusFailed {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if err := p.Shutdown(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if j := store.jobs["abc"]; j.Status != StatusFailed || j.Error != tt.want {
+				t.Errorf("expected failed job with error %q but got %v", tt.want, j)
+			}
+		})
+	}
+}
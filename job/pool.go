@@ -0,0 +1,262 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/connapotae/assessment-tax/tax"
+)
+
+// checkpointEvery is the number of results a worker keeps in memory
+// before saving them together with the progress of the job.
+const checkpointEvery int = 500
+
+// Pool runs batch jobs on a fixed number of workers. Jobs are queued in
+// memory; their state lives in the store so that a restart picks up the
+// jobs that were queued or running.
+//
+// Several instances may share the store. A pool leases a job under its
+// own name before running it and renews the lease while it runs, so that
+// no job runs twice at once; a job whose lease runs out, because its
+// instance went away, is taken over by the next pool that sweeps.
+type Pool struct {
+	store   Storer
+	calc    Calculator
+	workers int
+	owner   string
+	lease   time.Duration
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []string
+	queued map[string]bool
+	closed bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewPool(db Storer, calc Calculator, workers int, lease time.Duration) (*Pool, error) {
+	owner, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool{store: db, calc: calc, workers: workers, owner: owner, lease: lease, queued: map[string]bool{}, ctx: ctx, cancel: cancel}
+	p.cond = sync.NewCond(&p.mu)
+	return p, nil
+}
+
+// Start launches the workers and the sweep for abandoned jobs.
+func (p *Pool) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	go p.sweep()
+}
+
+// Resume claims the jobs that are queued or whose lease ran out, such as
+// those left unfinished by a previous run, and queues them.
+func (p *Pool) Resume() error {
+	jobs, err := p.store.ClaimPendingJobs(p.ctx, p.owner, p.lease)
+	if err != nil {
+		return err
+	}
+	for _, j := range jobs {
+		p.Enqueue(j.ID)
+	}
+	return nil
+}
+
+// sweep resumes the abandoned jobs once every lease until the pool is
+// shut down.
+func (p *Pool) sweep() {
+	ticker := time.NewTicker(p.lease)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := p.Resume(); err != nil {
+			log.Printf("resume jobs: %v", err)
+		}
+	}
+}
+
+// Enqueue queues a job unless it is already queued or running in this
+// pool.
+func (p *Pool) Enqueue(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.queued[id] {
+		return
+	}
+	p.queued[id] = true
+	p.queue = append(p.queue, id)
+	p.cond.Signal()
+}
+
+// Shutdown stops taking jobs from the queue and waits for the running
+// ones to drain. Once ctx is done the running jobs are cancelled; they
+// save their progress and are resumed on the next start.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) next() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for len(p.queue) == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if p.closed {
+		return "", false
+	}
+	id := p.queue[0]
+	p.queue = p.queue[1:]
+	return id, true
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+	for {
+		id, ok := p.next()
+		if !ok {
+			return
+		}
+		if err := p.run(id); err != nil {
+			log.Printf("job %s: %v", id, err)
+		}
+		p.mu.Lock()
+		delete(p.queued, id)
+		p.mu.Unlock()
+	}
+}
+
+// run processes a job. The progress and status are saved without the
+// context of the pool, so that a job cancelled by Shutdown still records
+// where it stopped; the store bounds those writes with its own timeout.
+func (p *Pool) run(id string) error {
+	bg := context.Background()
+	j, err := p.store.ClaimJob(p.ctx, id, p.owner, p.lease)
+	if errors.Is(err, ErrNotClaimed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	input, err := p.store.GetJobInput(p.ctx, id)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+	var lost atomic.Bool
+	go p.renew(ctx, id, func() {
+		lost.Store(true)
+		cancel()
+	})
+
+	processed, failed := j.Processed, j.Failed
+	var batch []tax.RowResult
+	checkpoint := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := p.store.SaveJobProgress(bg, id, p.owner, processed, failed, batch)
+		batch = nil
+		return err
+	}
+
+	err = p.calc.ProcessCSV(ctx, bytes.NewReader(input), j.Options, j.Processed, func(res tax.RowResult) error {
+		batch = append(batch, res)
+		processed++
+		if res.Result == nil {
+			failed++
+		}
+		if len(batch) >= checkpointEvery {
+			return checkpoint()
+		}
+		return nil
+	})
+	if lost.Load() {
+		return ErrNotClaimed
+	}
+	if cerr := checkpoint(); cerr != nil {
+		return cerr
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return p.store.UpdateJobStatus(bg, id, p.owner, StatusQueued, "")
+	case err != nil:
+		return p.store.UpdateJobStatus(bg, id, p.owner, StatusFailed, failure(id, j.Options.Lang, err))
+	default:
+		return p.store.UpdateJobStatus(bg, id, p.owner, StatusDone, "")
+	}
+}
+
+// failure returns the error shown to the client for a job that failed
+// with err. Only the errors of the file itself are shown; the others may
+// hold details of the database, so they are logged instead.
+func failure(id string, lang string, err error) string {
+	var fe *tax.FileError
+	if errors.As(err, &fe) {
+		return fe.Error()
+	}
+	log.Printf("job %s: %v", id, err)
+	return i18n.T(lang, i18n.JobFailed)
+}
+
+// renew keeps the lease of a running job until ctx is done, and calls
+// lost once the job turns out to be leased to another pool.
+func (p *Pool) renew(ctx context.Context, id string, lost func()) {
+	ticker := time.NewTicker(p.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := p.store.RenewJobLease(ctx, id, p.owner)
+		if errors.Is(err, ErrNotClaimed) {
+			lost()
+			return
+		}
+		if err != nil {
+			log.Printf("job %s: renew lease: %v", id, err)
+		}
+	}
+}
@@ -6,11 +6,16 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/connapotae/assessment-tax/admin"
 	"github.com/connapotae/assessment-tax/config"
+	"github.com/connapotae/assessment-tax/history"
+	"github.com/connapotae/assessment-tax/job"
 	"github.com/connapotae/assessment-tax/postgres"
+	"github.com/connapotae/assessment-tax/profile"
 	"github.com/connapotae/assessment-tax/tax"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -24,24 +29,93 @@ func main() {
 		panic(err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(p, os.Args[2:]); err != nil {
+			fmt.Println("migrate:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cfg.AutoMigrate() {
+		err = p.Migrate()
+	} else {
+		err = p.CheckSchema()
+	}
+	if err != nil {
+		panic(err)
+	}
+
 	e := echo.New()
+	e.Use(middleware.RequestID())
 	e.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "Hello, Go Bootcamp!")
 	})
 
-	taxHandler := tax.New(p)
+	rules := tax.NewRulesCache(p, cfg.RulesCacheTTL())
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go func() {
+		if err := p.WatchRules(watchCtx, rules.Invalidate, cfg.RulesPollInterval()); err != nil {
+			fmt.Println("rules watcher:", err)
+		}
+	}()
+	taxHandler := tax.New(p).WithRulesCache(rules)
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	if cfg.History().Enabled() {
+		taxHandler.WithRecorder(p)
+		go history.Purge(purgeCtx, p, cfg.History().Retention(), time.Hour)
+	}
 	e.POST("/tax/calculations", taxHandler.TaxCalculationsHandler)
 	e.POST("/tax/calculations/upload-csv", taxHandler.TaxCalculationsCSVHandler)
+	e.POST("/tax/calculations/household", taxHandler.HouseholdCalculationsHandler)
+	e.POST("/tax/calculations/withholding", taxHandler.WithholdingCalculationsHandler)
 
-	adminHandler := admin.New(p)
-	a := e.Group("/admin")
-	a.Use(middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
+	adminAuth := middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
 		if username == cfg.Admin().User() && password == cfg.Admin().Pass() {
+			c.Set(admin.ActorKey, username)
 			return true, nil
 		}
 		return false, nil
-	}))
+	})
+
+	// profiles hold tax IDs and allowances, so they are kept behind the
+	// same auth as the calculation history
+	profileHandler := profile.New(p)
+	pr := e.Group("/tax/profiles", adminAuth)
+	pr.POST("", profileHandler.CreateProfileHandler)
+	pr.GET("/:id", profileHandler.GetProfileHandler)
+	pr.PUT("/:id", profileHandler.UpdateProfileHandler)
+	pr.DELETE("/:id", profileHandler.DeleteProfileHandler)
+
+	pool, err := job.NewPool(p, taxHandler, cfg.JobWorkers(), cfg.JobLease())
+	if err != nil {
+		panic(err)
+	}
+	if err := pool.Resume(); err != nil {
+		panic(err)
+	}
+	pool.Start()
+
+	jobHandler := job.New(p, taxHandler, pool).WithMaxUpload(cfg.JobMaxUpload())
+	e.POST("/tax/calculations/jobs", jobHandler.CreateJobHandler)
+	e.GET("/tax/calculations/jobs/:id", jobHandler.GetJobHandler)
+	e.GET("/tax/calculations/jobs/:id/result", jobHandler.GetJobResultHandler)
+
+	adminHandler := admin.New(p).WithInvalidator(rules)
+	a := e.Group("/admin")
+	a.Use(adminAuth)
 	a.POST("/deductions/:deductType", adminHandler.SetupDeductionHandler)
+	a.POST("/exchange-rates/:currency", adminHandler.SetupExchangeRateHandler)
+	a.GET("/tax-levels", adminHandler.GetTaxLevelsHandler)
+	a.PUT("/tax-levels", adminHandler.ReplaceTaxLevelsHandler)
+	a.POST("/tax-levels/preview", adminHandler.PreviewTaxLevelsHandler)
+	a.GET("/audit", adminHandler.GetAuditLogHandler)
+
+	historyHandler := history.New(p)
+	a.GET("/calculations/history", historyHandler.ListHistoryHandler)
+	a.GET("/calculations/history/:id", historyHandler.GetHistoryHandler)
 
 	go func() {
 		if err := e.Start(cfg.Port()); err != nil && err != http.ErrServerClosed {
@@ -57,5 +131,50 @@ func main() {
 	if err := e.Shutdown(context.Background()); err != nil {
 		e.Logger.Fatal("shutdown err:", err)
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := pool.Shutdown(ctx); err != nil {
+		fmt.Println("batch jobs checkpointed:", err)
+	}
 	fmt.Println("shutdown complete.")
 }
+
+// migrate runs the schema migrations from the command line:
+//
+//	migrate up | down [steps] | version
+func migrate(p *postgres.Postgres, args []string) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		if err := p.Migrate(); err != nil {
+			return err
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+			steps = n
+		}
+		if err := p.MigrateDown(steps); err != nil {
+			return err
+		}
+	case "version":
+	default:
+		return fmt.Errorf("unknown command %q, use up, down [steps] or version", cmd)
+	}
+
+	current, latest, err := p.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema version %d of %d\n", current, latest)
+	return nil
+}
@@ -0,0 +1,115 @@
+package money
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount is a money value rendered for printing.
+type Amount struct {
+	Text  string `json:"text"`
+	Words string `json:"words"`
+}
+
+var digits = []string{"ศูนย์", "หนึ่ง", "สอง", "สาม", "สี่", "ห้า", "หก", "เจ็ด", "แปด", "เก้า"}
+var places = []string{"", "สิบ", "ร้อย", "พัน", "หมื่น", "แสน"}
+
+// New renders v as both a formatted number and Thai words.
+func New(v float64) Amount {
+	return Amount{Text: Format(v), Words: BahtText(v)}
+}
+
+// satang rounds v to the nearest satang.
+func satang(v float64) int64 {
+	return int64(math.Round(math.Abs(v) * 100))
+}
+
+// Format renders v with thousands separators and two decimals, e.g.
+// 1234567.5 becomes "1,234,567.50".
+func Format(v float64) string {
+	s := satang(v)
+	whole := strconv.FormatInt(s/100, 10)
+
+	var b strings.Builder
+	if v < 0 && s > 0 {
+		b.WriteString("-")
+	}
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(",")
+		}
+		b.WriteRune(r)
+	}
+	b.WriteString(".")
+	frac := strconv.FormatInt(s%100, 10)
+	if len(frac) == 1 {
+		b.WriteString("0")
+	}
+	b.WriteString(frac)
+	return b.String()
+}
+
+// BahtText renders v in Thai words the way amounts are written on
+// cheques and receipts, e.g. 10000 becomes "หนึ่งหมื่นบาทถ้วน".
+func BahtText(v float64) string {
+	s := satang(v)
+	baht, st := s/100, s%100
+
+	var b strings.Builder
+	if v < 0 && s > 0 {
+		b.WriteString("ลบ")
+	}
+	if baht > 0 || st == 0 {
+		b.WriteString(read(baht))
+		b.WriteString("บาท")
+	}
+	if st == 0 {
+		b.WriteString("ถ้วน")
+		return b.String()
+	}
+	b.WriteString(read(st))
+	b.WriteString("สตางค์")
+	return b.String()
+}
+
+// read spells out n, splitting it in groups of millions.
+func read(n int64) string {
+	if n == 0 {
+		return digits[0]
+	}
+	if n >= 1000000 {
+		rest := n % 1000000
+		s := read(n/1000000) + "ล้าน"
+		if rest > 0 {
+			s += readGroup(rest, true)
+		}
+		return s
+	}
+	return readGroup(n, false)
+}
+
+// readGroup spells out n below one million. The unit digit one reads as
+// "เอ็ด" whenever a higher digit precedes it.
+func readGroup(n int64, hasHigher bool) string {
+	var b strings.Builder
+	s := strconv.FormatInt(n, 10)
+	for i, r := range s {
+		d := int(r - '0')
+		place := len(s) - i - 1
+		if d == 0 {
+			continue
+		}
+		switch {
+		case place == 0 && d == 1 && (n > 10 || hasHigher):
+			b.WriteString("เอ็ด")
+		case place == 1 && d == 1:
+			b.WriteString(places[1])
+		case place == 1 && d == 2:
+			b.WriteString("ยี่" + places[1])
+		default:
+			b.WriteString(digits[d] + places[place])
+		}
+	}
+	return b.String()
+}
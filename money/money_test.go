@@ -0,0 +1,46 @@
+package money
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{in: 0, want: "0.00"},
+		{in: 29000, want: "29,000.00"},
+		{in: 1234567.5, want: "1,234,567.50"},
+		{in: 100.005, want: "100.01"},
+		{in: -1500, want: "-1,500.00"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.in); got != tt.want {
+			t.Errorf("Format(%v) expected %s but got %s", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestBahtText(t *testing.T) {
+	tests := []struct {
+		in   float64
+		want string
+	}{
+		{in: 0, want: "ศูนย์บาทถ้วน"},
+		{in: 1, want: "หนึ่งบาทถ้วน"},
+		{in: 11, want: "สิบเอ็ดบาทถ้วน"},
+		{in: 21, want: "ยี่สิบเอ็ดบาทถ้วน"},
+		{in: 101, want: "หนึ่งร้อยเอ็ดบาทถ้วน"},
+		{in: 10000, want: "หนึ่งหมื่นบาทถ้วน"},
+		{in: 29000, want: "สองหมื่นเก้าพันบาทถ้วน"},
+		{in: 1000001, want: "หนึ่งล้านเอ็ดบาทถ้วน"},
+		{in: 21000000, want: "ยี่สิบเอ็ดล้านบาทถ้วน"},
+		{in: 1234.5, want: "หนึ่งพันสองร้อยสามสิบสี่บาทห้าสิบสตางค์"},
+		{in: 0.25, want: "ยี่สิบห้าสตางค์"},
+		{in: -6000, want: "ลบหกพันบาทถ้วน"},
+	}
+	for _, tt := range tests {
+		if got := BahtText(tt.in); got != tt.want {
+			t.Errorf("BahtText(%v) expected %s but got %s", tt.in, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/connapotae/assessment-tax/admin"
+)
+
+// audit appends a change to the audit log within tx. A nil old value is
+// stored as null.
+func audit(ctx context.Context, tx *sql.Tx, entity, key string, old, new any, change admin.Change) error {
+	var oldValue []byte
+	if old != nil {
+		b, err := json.Marshal(old)
+		if err != nil {
+			return err
+		}
+		oldValue = b
+	}
+	newValue, err := json.Marshal(new)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO config_audit (entity, key, old_value, new_value, actor, request_id) VALUES ($1, $2, $3, $4, $5, $6)",
+		entity, key, oldValue, newValue, change.Actor, change.RequestID)
+	return err
+}
+
+func scanAudit(row interface{ Scan(...any) error }) (admin.AuditEntry, error) {
+	var a admin.AuditEntry
+	var oldValue, newValue []byte
+	err := row.Scan(
+		&a.ID,
+		&a.Entity,
+		&a.Key,
+		&oldValue,
+		&newValue,
+		&a.Actor,
+		&a.RequestID,
+		&a.ChangedAt,
+	)
+	a.OldValue = json.RawMessage("null")
+	if oldValue != nil {
+		a.OldValue = oldValue
+	}
+	a.NewValue = newValue
+	return a, err
+}
+
+const auditColumns = `id, entity, key, old_value, new_value, actor, request_id, changed_at`
+
+// GetAuditLog returns one page of the audit entries matching f, newest
+// first, and the number of entries matching f in total.
+func (p *Postgres) GetAuditLog(ctx context.Context, f admin.AuditFilter) (_ []admin.AuditEntry, _ int, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	var where []string
+	var args []any
+	if f.Entity != "" {
+		args = append(args, f.Entity)
+		where = append(where, fmt.Sprintf("entity = $%d", len(args)))
+	}
+	if f.Key != "" {
+		args = append(args, f.Key)
+		where = append(where, fmt.Sprintf("key = $%d", len(args)))
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " where " + strings.Join(where, " and ")
+	}
+
+	var total int
+	if err := p.Db.QueryRowContext(ctx, `select count(*) from config_audit`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, f.PageSize, (f.Page-1)*f.PageSize)
+	rows, err := p.Db.QueryContext(ctx, fmt.Sprintf(`select `+auditColumns+` from config_audit%s order by changed_at desc, id desc limit $%d offset $%d`, cond, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var entries []admin.AuditEntry
+	for rows.Next() {
+		a, err := scanAudit(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, a)
+	}
+	return entries, total, rows.Err()
+}
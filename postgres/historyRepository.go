@@ -0,0 +1,141 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/connapotae/assessment-tax/history"
+	"github.com/lib/pq"
+)
+
+func (p *Postgres) SaveCalculation(ctx context.Context, r history.Record) (err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	_, err = p.Db.ExecContext(ctx, "INSERT INTO calculation_history (kind, tax_ids, input, result, ruleset_version, client) VALUES ($1, $2, $3, $4, $5, $6)",
+		r.Kind, pq.Array(r.TaxIDs), []byte(r.Input), []byte(r.Result), r.RulesetVersion, r.Client)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// SaveCalculations stores a batch of records in one transaction.
+func (p *Postgres) SaveCalculations(ctx context.Context, records []history.Record) (err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	tx, err := p.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO calculation_history (kind, tax_ids, input, result, ruleset_version, client) VALUES ($1, $2, $3, $4, $5, $6)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range records {
+		if _, err := stmt.ExecContext(ctx, r.Kind, pq.Array(r.TaxIDs), []byte(r.Input), []byte(r.Result), r.RulesetVersion, r.Client); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func scanRecord(row interface{ Scan(...any) error }) (history.Record, error) {
+	var r history.Record
+	var input, result []byte
+	err := row.Scan(
+		&r.ID,
+		&r.Kind,
+		pq.Array(&r.TaxIDs),
+		&input,
+		&result,
+		&r.RulesetVersion,
+		&r.Client,
+		&r.CreatedAt,
+	)
+	r.Input = input
+	r.Result = result
+	return r, err
+}
+
+const historyColumns = `id, kind, tax_ids, input, result, ruleset_version, client, created_at`
+
+// ListCalculations returns one page of the records matching f, newest
+// first, and the number of records matching f in total.
+func (p *Postgres) ListCalculations(ctx context.Context, f history.Filter) (_ []history.Record, _ int, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	var where []string
+	var args []any
+	if f.TaxID != "" {
+		args = append(args, f.TaxID)
+		where = append(where, fmt.Sprintf("$%d = ANY(tax_ids)", len(args)))
+	}
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		where = append(where, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		where = append(where, fmt.Sprintf("created_at <= $%d", len(args)))
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " where " + strings.Join(where, " and ")
+	}
+
+	var total int
+	if err := p.Db.QueryRowContext(ctx, `select count(*) from calculation_history`+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, f.PageSize, (f.Page-1)*f.PageSize)
+	rows, err := p.Db.QueryContext(ctx, fmt.Sprintf(`select `+historyColumns+` from calculation_history%s order by created_at desc, id desc limit $%d offset $%d`, cond, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var records []history.Record
+	for rows.Next() {
+		r, err := scanRecord(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, r)
+	}
+	return records, total, rows.Err()
+}
+
+func (p *Postgres) GetCalculation(ctx context.Context, id int64) (_ history.Record, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	row := p.Db.QueryRowContext(ctx, `select `+historyColumns+` from calculation_history where id = $1`, id)
+	r, err := scanRecord(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, history.ErrNotFound
+	}
+	return r, err
+}
+
+func (p *Postgres) PurgeCalculations(ctx context.Context, before time.Time) (_ int64, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	res, err := p.Db.ExecContext(ctx, "DELETE FROM calculation_history WHERE created_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,238 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/connapotae/assessment-tax/job"
+	"github.com/connapotae/assessment-tax/tax"
+)
+
+func (p *Postgres) CreateJob(ctx context.Context, j job.Job, input []byte) (err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	options, err := json.Marshal(j.Options)
+	if err != nil {
+		return err
+	}
+	_, err = p.Db.ExecContext(ctx, "INSERT INTO calculation_job (id, status, options, total_rows, input) VALUES ($1, $2, $3, $4, $5)", j.ID, j.Status, options, j.Total, input)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func scanJob(row interface{ Scan(...any) error }) (job.Job, error) {
+	var j job.Job
+	var options []byte
+	err := row.Scan(
+		&j.ID,
+		&j.Status,
+		&options,
+		&j.Total,
+		&j.Processed,
+		&j.Failed,
+		&j.Error,
+		&j.CreatedAt,
+		&j.UpdatedAt,
+	)
+	if err != nil {
+		return j, err
+	}
+	err = json.Unmarshal(options, &j.Options)
+	return j, err
+}
+
+const jobColumns = `id, status, options, total_rows, processed_rows, failed_rows, error, created_at, updated_at`
+
+func (p *Postgres) GetJob(ctx context.Context, id string) (_ job.Job, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	row := p.Db.QueryRowContext(ctx, `select `+jobColumns+` from calculation_job where id = $1`, id)
+	j, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return j, job.ErrNotFound
+	}
+	return j, err
+}
+
+func (p *Postgres) GetJobInput(ctx context.Context, id string) (_ []byte, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	var input []byte
+	err = p.Db.QueryRowContext(ctx, `select input from calculation_job where id = $1`, id).Scan(&input)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, job.ErrNotFound
+	}
+	return input, err
+}
+
+// expiredLease is the condition of a running job whose owner stopped
+// renewing it for longer than the lease of $n seconds.
+func expiredLease(n int) string {
+	return fmt.Sprintf(`COALESCE(heartbeat, '-infinity') < now() - make_interval(secs => $%d)`, n)
+}
+
+// ClaimJob leases a job to owner when it is queued, already leased to
+// owner, or running under a lease that ran out. Any other job is returned
+// as job.ErrNotClaimed.
+func (p *Postgres) ClaimJob(ctx context.Context, id string, owner string, lease time.Duration) (_ job.Job, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	row := p.Db.QueryRowContext(ctx, `UPDATE calculation_job SET status = $1, owner = $2, heartbeat = now(), updated_at = now()
+		WHERE id = $3 AND (status = $4 OR (status = $1 AND (owner = $2 OR `+expiredLease(5)+`)))
+		RETURNING `+jobColumns, job.StatusRunning, owner, id, job.StatusQueued, lease.Seconds())
+	j, err := scanJob(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return j, job.ErrNotClaimed
+	}
+	return j, err
+}
+
+// ClaimPendingJobs leases to owner every queued job and every running job
+// whose lease ran out. Jobs locked by another instance that is claiming
+// them at the same time are skipped rather than waited for.
+func (p *Postgres) ClaimPendingJobs(ctx context.Context, owner string, lease time.Duration) (_ []job.Job, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	rows, err := p.Db.QueryContext(ctx, `WITH claimed AS (
+			UPDATE calculation_job SET status = $1, owner = $2, heartbeat = now(), updated_at = now()
+			WHERE id IN (
+				SELECT id FROM calculation_job
+				WHERE status = $3 OR (status = $1 AND `+expiredLease(4)+`)
+				ORDER BY created_at
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING `+jobColumns+`
+		)
+		SELECT `+jobColumns+` FROM claimed ORDER BY created_at`, job.StatusRunning, owner, job.StatusQueued, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []job.Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, rows.Err()
+}
+
+// RenewJobLease extends the lease of a running job held by owner.
+func (p *Postgres) RenewJobLease(ctx context.Context, id string, owner string) (err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	res, err := p.Db.ExecContext(ctx, "UPDATE calculation_job SET heartbeat = now() WHERE id = $1 AND owner = $2 AND status = $3", id, owner, job.StatusRunning)
+	if err != nil {
+		return err
+	}
+	return claimed(res)
+}
+
+// claimed tells whether an update guarded by the owner of a job matched
+// it.
+func claimed(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return job.ErrNotClaimed
+	}
+	return nil
+}
+
+// SaveJobProgress stores a batch of results together with the progress
+// they bring the job to, so that a resumed job neither loses nor repeats
+// rows. Nothing is stored once owner has lost the lease of the job.
+func (p *Postgres) SaveJobProgress(ctx context.Context, id string, owner string, processed int, failed int, results []tax.RowResult) (err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	tx, err := p.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// updating the job first locks it, so that it cannot be taken over
+	// while its results are written
+	res, err := tx.ExecContext(ctx, "UPDATE calculation_job SET processed_rows = $1, failed_rows = $2, heartbeat = now(), updated_at = now() WHERE id = $3 AND owner = $4", processed, failed, id, owner)
+	if err != nil {
+		return err
+	}
+	if err := claimed(res); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO calculation_job_result (job_id, line, result) VALUES ($1, $2, $3) ON CONFLICT (job_id, line) DO NOTHING")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range results {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.ExecContext(ctx, id, r.Line, b); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateJobStatus sets the status of a job leased to owner and releases
+// the lease.
+func (p *Postgres) UpdateJobStatus(ctx context.Context, id string, owner string, status string, errMsg string) (err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	res, err := p.Db.ExecContext(ctx, "UPDATE calculation_job SET status = $1, error = $2, owner = '', heartbeat = NULL, updated_at = now() WHERE id = $3 AND owner = $4", status, errMsg, id, owner)
+	if err != nil {
+		return err
+	}
+	return claimed(res)
+}
+
+// EachJobResult calls fn with every result of a job in the order of
+// their lines, reading them as fn takes them so that a large job is never
+// held in memory. As fn usually writes to a client, the query is bounded
+// by ctx rather than by the query timeout.
+func (p *Postgres) EachJobResult(ctx context.Context, id string, fn func(tax.RowResult) error) error {
+	rows, err := p.Db.QueryContext(ctx, `select result from calculation_job_result where job_id = $1 order by line`, id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b []byte
+		if err := rows.Scan(&b); err != nil {
+			return err
+		}
+		var r tax.RowResult
+		if err := json.Unmarshal(b, &r); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,232 @@
+package postgres
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock is the advisory lock key that keeps instances started at
+// the same time from applying a migration twice.
+const migrationLock = 74261001
+
+// ErrSchemaTooNew is returned when the database has migrations applied
+// that this binary does not know about.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// migration is one versioned schema change read from a pair of files
+// named <version>_<name>.up.sql and <version>_<name>.down.sql.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads the migrations of fsys sorted by version. Every
+// migration must have both an up and a down file and versions must
+// count up from 1 without gaps.
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, f := range files {
+		base := path.Base(f)
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s: not an .up.sql or .down.sql file", base)
+		}
+
+		stem := strings.TrimSuffix(base, "."+direction+".sql")
+		v, name, ok := strings.Cut(stem, "_")
+		version, err := strconv.Atoi(v)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a version", base)
+		}
+
+		content, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: name}
+			byVersion[version] = m
+		}
+		if m.name != name {
+			return nil, fmt.Errorf("migration %d: named both %s and %s", version, m.name, name)
+		}
+		if direction == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	var migrations []migration
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i, m := range migrations {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %d: missing version %d", m.version, i+1)
+		}
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d: needs both an up and a down file", m.version)
+		}
+	}
+	return migrations, nil
+}
+
+func (p *Postgres) ensureMigrationTable() error {
+	tx, err := p.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version int PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SchemaVersion returns the latest migration applied to the database and
+// the latest one embedded in the binary.
+func (p *Postgres) SchemaVersion() (current int, latest int, err error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := p.ensureMigrationTable(); err != nil {
+		return 0, 0, err
+	}
+	err = p.Db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	return current, len(migrations), err
+}
+
+// CheckSchema refuses a database that was migrated by a newer binary.
+func (p *Postgres) CheckSchema() error {
+	current, latest, err := p.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > latest {
+		return fmt.Errorf("%w: version %d, binary knows up to %d", ErrSchemaTooNew, current, latest)
+	}
+	return nil
+}
+
+// Migrate applies every migration the database does not have yet, each
+// in its own transaction.
+func (p *Postgres) Migrate() error {
+	if err := p.CheckSchema(); err != nil {
+		return err
+	}
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if err := p.apply(m, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the latest steps migrations.
+func (p *Postgres) MigrateDown(steps int) error {
+	if err := p.CheckSchema(); err != nil {
+		return err
+	}
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		applied, err := p.isApplied(p.Db, migrations[i].version)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			continue
+		}
+		if err := p.apply(migrations[i], false); err != nil {
+			return err
+		}
+		steps--
+	}
+	return nil
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (p *Postgres) isApplied(q queryRower, version int) (bool, error) {
+	var applied bool
+	err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied)
+	return applied, err
+}
+
+// apply runs the up or down script of m together with its bookkeeping
+// in one transaction. The state is checked again under the lock so that
+// a migration another instance applied meanwhile is skipped.
+func (p *Postgres) apply(m migration, up bool) error {
+	tx, err := p.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLock); err != nil {
+		return err
+	}
+	applied, err := p.isApplied(tx, m.version)
+	if err != nil {
+		return err
+	}
+	if applied == up {
+		return nil
+	}
+
+	script, record := m.up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+	if !up {
+		script, record = m.down, `DELETE FROM schema_migrations WHERE version = $1 AND name = $2`
+	}
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %d_%s: %w", m.version, m.name, err)
+	}
+	if _, err := tx.Exec(record, m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
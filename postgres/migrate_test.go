@@ -0,0 +1,76 @@
+package postgres
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	t.Run("given embedded migrations should load every version in order with up and down", func(t *testing.T) {
+		migrations, err := loadMigrations(migrationFiles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) == 0 {
+			t.Fatal("expected embedded migrations")
+		}
+		for i, m := range migrations {
+			if m.version != i+1 || m.up == "" || m.down == "" {
+				t.Errorf("expected migration %d with up and down but got %d %q", i+1, m.version, m.name)
+			}
+		}
+	})
+
+	t.Run("given database from the first init.sql should add label_en before seeding tax levels", func(t *testing.T) {
+		b, err := migrationFiles.ReadFile("migrations/0001_create_tax_rules.up.sql")
+		if err != nil {
+			t.Fatal(err)
+		}
+		up := string(b)
+		alter := strings.Index(up, "ADD COLUMN IF NOT EXISTS label_en")
+		insert := strings.Index(up, "INSERT INTO tax_level")
+		if alter < 0 || insert < 0 || alter > insert {
+			t.Errorf("expected label_en to be added before tax levels are inserted")
+		}
+	})
+
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		err   string
+	}{
+		{
+			name: "given migration without down file should return error",
+			files: fstest.MapFS{
+				"migrations/0001_init.up.sql": {Data: []byte("CREATE TABLE a (id int);")},
+			},
+			err: "needs both an up and a down file",
+		},
+		{
+			name: "given gap between versions should return error",
+			files: fstest.MapFS{
+				"migrations/0001_init.up.sql":   {Data: []byte("CREATE TABLE a (id int);")},
+				"migrations/0001_init.down.sql": {Data: []byte("DROP TABLE a;")},
+				"migrations/0003_b.up.sql":      {Data: []byte("CREATE TABLE b (id int);")},
+				"migrations/0003_b.down.sql":    {Data: []byte("DROP TABLE b;")},
+			},
+			err: "missing version 2",
+		},
+		{
+			name: "given file without version should return error",
+			files: fstest.MapFS{
+				"migrations/init.up.sql": {Data: []byte("CREATE TABLE a (id int);")},
+			},
+			err: "must start with a version",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMigrations(tt.files)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error %q but got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS exchange_rate;
+DROP TABLE IF EXISTS deduction;
+DROP TABLE IF EXISTS tax_level;
@@ -0,0 +1,58 @@
+CREATE TABLE IF NOT EXISTS tax_level (
+	id serial PRIMARY KEY,
+	level int NOT NULL,
+	label varchar(20) NOT NULL,
+	label_en varchar(30) NOT NULL DEFAULT '',
+	min_amount numeric NOT NULL,
+	max_amount numeric NOT NULL,
+	tax_percent int NOT NULL
+);
+
+-- databases created from the first init.sql have tax_level without
+-- label_en; their levels get the English labels of the seeded ones, and
+-- any other level keeps showing its Thai label
+ALTER TABLE tax_level ADD COLUMN IF NOT EXISTS label_en varchar(30) NOT NULL DEFAULT '';
+
+UPDATE tax_level t SET label_en = v.label_en FROM (VALUES
+	 ('0-150,000','0-150,000'),
+	 ('150,001-500,000','150,001-500,000'),
+	 ('500,001-1,000,000','500,001-1,000,000'),
+	 ('1,000,001-2,000,000','1,000,001-2,000,000'),
+	 ('2,000,001 ขึ้นไป','2,000,001 and above')
+) AS v(label,label_en) WHERE t.label = v.label AND t.label_en = '';
+
+-- the rules are only seeded into an empty tax_level, so the levels of
+-- a database created from init.sql are kept
+INSERT INTO tax_level (level,label,label_en,min_amount,max_amount,tax_percent)
+SELECT v.* FROM (VALUES
+	 (1,'0-150,000','0-150,000',0,150000,0),
+	 (2,'150,001-500,000','150,001-500,000',150000,500000,10),
+	 (3,'500,001-1,000,000','500,001-1,000,000',500000,1000000,15),
+	 (4,'1,000,001-2,000,000','1,000,001-2,000,000',1000000,2000000,20),
+	 (5,'2,000,001 ขึ้นไป','2,000,001 and above',2000000,'infinity'::numeric,35)
+) AS v WHERE NOT EXISTS (SELECT 1 FROM tax_level);
+
+CREATE TABLE IF NOT EXISTS deduction (
+	id serial PRIMARY KEY,
+	deduct_type varchar(20) NOT NULL,
+	deduct_amount numeric NOT NULL
+);
+
+INSERT INTO deduction (deduct_type,deduct_amount)
+SELECT v.* FROM (VALUES
+	('personal',60000),
+	('donation',100000),
+	('k-receipt',50000)
+) AS v WHERE NOT EXISTS (SELECT 1 FROM deduction);
+
+CREATE TABLE IF NOT EXISTS exchange_rate (
+	id serial PRIMARY KEY,
+	currency varchar(3) NOT NULL UNIQUE,
+	rate numeric NOT NULL
+);
+
+INSERT INTO exchange_rate (currency,rate)
+SELECT v.* FROM (VALUES
+	('USD',36.5),
+	('EUR',39.5)
+) AS v WHERE NOT EXISTS (SELECT 1 FROM exchange_rate);
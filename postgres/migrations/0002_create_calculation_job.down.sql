@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS calculation_job_result;
+DROP TABLE IF EXISTS calculation_job;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS calculation_job (
+	id varchar(32) PRIMARY KEY,
+	status varchar(20) NOT NULL,
+	options jsonb NOT NULL,
+	total_rows int NOT NULL DEFAULT 0,
+	processed_rows int NOT NULL DEFAULT 0,
+	failed_rows int NOT NULL DEFAULT 0,
+	error text NOT NULL DEFAULT '',
+	input bytea NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS calculation_job_result (
+	job_id varchar(32) NOT NULL REFERENCES calculation_job (id) ON DELETE CASCADE,
+	line int NOT NULL,
+	result jsonb NOT NULL,
+	PRIMARY KEY (job_id, line)
+);
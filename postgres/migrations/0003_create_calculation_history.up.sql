@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS calculation_history (
+	id bigserial PRIMARY KEY,
+	kind varchar(20) NOT NULL,
+	tax_ids text[] NOT NULL DEFAULT '{}',
+	input jsonb NOT NULL,
+	result jsonb NOT NULL,
+	ruleset_version varchar(20) NOT NULL,
+	client varchar(64) NOT NULL DEFAULT '',
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS calculation_history_tax_ids_idx ON calculation_history USING gin (tax_ids);
+CREATE INDEX IF NOT EXISTS calculation_history_created_at_idx ON calculation_history (created_at);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS taxpayer_profile;
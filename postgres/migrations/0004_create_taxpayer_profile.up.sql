@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS taxpayer_profile (
+	id varchar(32) PRIMARY KEY,
+	tax_id varchar(13) NOT NULL UNIQUE,
+	name text NOT NULL DEFAULT '',
+	allowances jsonb NOT NULL DEFAULT '[]',
+	created_at timestamptz NOT NULL DEFAULT now(),
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS config_audit;
+DROP FUNCTION IF EXISTS config_audit_append_only();
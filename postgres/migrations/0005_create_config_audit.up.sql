@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS config_audit (
+	id bigserial PRIMARY KEY,
+	entity varchar(30) NOT NULL,
+	key varchar(30) NOT NULL,
+	old_value jsonb,
+	new_value jsonb NOT NULL,
+	actor varchar(100) NOT NULL DEFAULT '',
+	request_id varchar(100) NOT NULL DEFAULT '',
+	changed_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS config_audit_entity_key_idx ON config_audit (entity, key, changed_at);
+
+-- the audit log is append-only
+CREATE OR REPLACE FUNCTION config_audit_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'config_audit is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS config_audit_append_only ON config_audit;
+CREATE TRIGGER config_audit_append_only
+	BEFORE UPDATE OR DELETE ON config_audit
+	FOR EACH ROW EXECUTE FUNCTION config_audit_append_only();
@@ -0,0 +1,12 @@
+-- keep only the value of each deduction in force today
+DELETE FROM deduction d
+WHERE d.effective_from > CURRENT_DATE
+	OR EXISTS (
+		SELECT 1 FROM deduction n
+		WHERE n.deduct_type = d.deduct_type
+			AND n.effective_from <= CURRENT_DATE
+			AND n.effective_from > d.effective_from
+	);
+
+ALTER TABLE deduction DROP CONSTRAINT IF EXISTS deduction_type_effective_from_key;
+ALTER TABLE deduction DROP COLUMN IF EXISTS effective_from;
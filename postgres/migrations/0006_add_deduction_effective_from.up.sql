@@ -0,0 +1,4 @@
+-- deductions already set apply to every date before the first scheduled change
+ALTER TABLE deduction ADD COLUMN effective_from date NOT NULL DEFAULT DATE '1970-01-01';
+ALTER TABLE deduction ALTER COLUMN effective_from SET DEFAULT CURRENT_DATE;
+ALTER TABLE deduction ADD CONSTRAINT deduction_type_effective_from_key UNIQUE (deduct_type, effective_from);
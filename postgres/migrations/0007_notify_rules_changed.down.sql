@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS deduction_notify_rules_changed ON deduction;
+DROP TRIGGER IF EXISTS tax_level_notify_rules_changed ON tax_level;
+DROP TRIGGER IF EXISTS exchange_rate_notify_rules_changed ON exchange_rate;
+DROP FUNCTION IF EXISTS notify_rules_changed();
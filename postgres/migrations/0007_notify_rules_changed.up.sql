@@ -0,0 +1,23 @@
+-- tell every instance listening on rules_changed that the rules changed;
+-- the notification is sent when the transaction commits
+CREATE OR REPLACE FUNCTION notify_rules_changed() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('rules_changed', TG_TABLE_NAME);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS deduction_notify_rules_changed ON deduction;
+CREATE TRIGGER deduction_notify_rules_changed
+	AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON deduction
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_rules_changed();
+
+DROP TRIGGER IF EXISTS tax_level_notify_rules_changed ON tax_level;
+CREATE TRIGGER tax_level_notify_rules_changed
+	AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON tax_level
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_rules_changed();
+
+DROP TRIGGER IF EXISTS exchange_rate_notify_rules_changed ON exchange_rate;
+CREATE TRIGGER exchange_rate_notify_rules_changed
+	AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON exchange_rate
+	FOR EACH STATEMENT EXECUTE FUNCTION notify_rules_changed();
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS calculation_job_pending_idx;
+ALTER TABLE calculation_job DROP COLUMN IF EXISTS heartbeat;
+ALTER TABLE calculation_job DROP COLUMN IF EXISTS owner;
@@ -0,0 +1,6 @@
+-- a running job belongs to the instance named in owner for as long as it
+-- keeps heartbeat fresh; once the lease runs out another instance may
+-- take the job over
+ALTER TABLE calculation_job ADD COLUMN owner varchar(32) NOT NULL DEFAULT '';
+ALTER TABLE calculation_job ADD COLUMN heartbeat timestamptz;
+CREATE INDEX IF NOT EXISTS calculation_job_pending_idx ON calculation_job (created_at) WHERE status IN ('queued', 'running');
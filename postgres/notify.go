@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// rulesChannel is the channel the database notifies on whenever the
+// deductions, tax levels or exchange rates change.
+const rulesChannel = "rules_changed"
+
+// WatchRules calls onChange whenever the rules change in the database,
+// whichever instance changed them. Until it listens for the changes, and
+// while the connection it listens on is down, changes cannot be heard, so
+// onChange is called every pollInterval instead. It returns when ctx is
+// done.
+func (p *Postgres) WatchRules(ctx context.Context, onChange func(), pollInterval time.Duration) error {
+	events := make(chan pq.ListenerEventType, 8)
+	var listening atomic.Bool
+	listener := pq.NewListener(p.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("rules listener: %v", err)
+		}
+		// a connection is of no use until the channel is listened on
+		if !listening.Load() {
+			return
+		}
+		select {
+		case events <- ev:
+		default:
+		}
+	})
+	defer listener.Close()
+
+	go func() {
+		if !listen(ctx, listener, pollInterval) {
+			return
+		}
+		listening.Store(true)
+		select {
+		case events <- pq.ListenerEventConnected:
+		case <-ctx.Done():
+		}
+	}()
+	watchRules(ctx, listener.NotificationChannel(), events, listener.Ping, onChange, pollInterval)
+	return nil
+}
+
+// listen listens on the rules channel, trying again every pollInterval
+// until it succeeds or ctx is done. Once listened on, the channel is
+// listened on again by the listener whenever it reconnects.
+func listen(ctx context.Context, listener *pq.Listener, pollInterval time.Duration) bool {
+	for {
+		err := listener.Listen(rulesChannel)
+		if err == nil {
+			return true
+		}
+		log.Printf("rules listener: listen: %v", err)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// watchRules calls onChange for every notification and polls while the
+// listener is not connected. Changes made while it was disconnected were
+// missed, so onChange is also called once it connects.
+func watchRules(ctx context.Context, notify <-chan *pq.Notification, events <-chan pq.ListenerEventType, ping func() error, onChange func(), pollInterval time.Duration) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	connected := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-notify:
+			onChange()
+		case ev := <-events:
+			switch ev {
+			case pq.ListenerEventConnected, pq.ListenerEventReconnected:
+				connected = true
+				onChange()
+			case pq.ListenerEventDisconnected:
+				connected = false
+			}
+		case <-ticker.C:
+			if !connected {
+				onChange()
+				continue
+			}
+			// a connection that died quietly is only noticed when used
+			go ping()
+		}
+	}
+}
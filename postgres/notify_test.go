@@ -0,0 +1,107 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestWatchRules(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notify := make(chan *pq.Notification)
+	events := make(chan pq.ListenerEventType)
+	changes := make(chan struct{}, 16)
+	pings := make(chan struct{}, 16)
+	go watchRules(ctx, notify, events, func() error {
+		pings <- struct{}{}
+		return nil
+	}, func() {
+		changes <- struct{}{}
+	}, 20*time.Millisecond)
+
+	expect := func(t *testing.T, ch chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s", what)
+		}
+	}
+	drain := func(ch chan struct{}) {
+		for {
+			select {
+			case <-ch:
+			default:
+				return
+			}
+		}
+	}
+
+	t.Run("given listener not connected should poll for changes", func(t *testing.T) {
+		expect(t, changes, "a change from polling")
+	})
+
+	t.Run("given listener connected should refresh once and stop polling", func(t *testing.T) {
+		events <- pq.ListenerEventConnected
+		expect(t, pings, "a ping while connected")
+		drain(changes)
+		expect(t, pings, "another ping while connected")
+		select {
+		case <-changes:
+			t.Errorf("expected no polling while connected")
+		default:
+		}
+	})
+
+	t.Run("given notification should refresh", func(t *testing.T) {
+		notify <- &pq.Notification{Channel: rulesChannel, Extra: "deduction"}
+		expect(t, changes, "a change from the notification")
+	})
+
+	t.Run("given listener disconnected should poll again", func(t *testing.T) {
+		events <- pq.ListenerEventDisconnected
+		drain(changes)
+		expect(t, changes, "a change from polling")
+		expect(t, changes, "another change from polling")
+	})
+}
+
+func TestWatchRulesWithoutDatabase(t *testing.T) {
+	t.Run("given database unreachable should poll for changes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		p := &Postgres{dsn: "host=127.0.0.1 port=1 sslmode=disable connect_timeout=1"}
+		changes := make(chan struct{}, 16)
+		done := make(chan error, 1)
+		go func() {
+			done <- p.WatchRules(ctx, func() {
+				changes <- struct{}{}
+			}, 20*time.Millisecond)
+		}()
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-changes:
+			case err := <-done:
+				t.Fatalf("expected watcher to keep polling, got %v", err)
+			case <-time.After(time.Second):
+				t.Fatalf("expected a change from polling")
+			}
+		}
+
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("expected nil but got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("expected watcher to return once cancelled")
+		}
+	})
+}
@@ -1,15 +1,19 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"log"
+	"time"
 
 	"github.com/connapotae/assessment-tax/config"
 	_ "github.com/lib/pq"
 )
 
 type Postgres struct {
-	Db *sql.DB
+	Db      *sql.DB
+	dsn     string
+	timeout time.Duration
 }
 
 func New(cfg config.IConfig) (*Postgres, error) {
@@ -24,5 +28,22 @@ func New(cfg config.IConfig) (*Postgres, error) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	return &Postgres{Db: db}, nil
+	return &Postgres{Db: db, dsn: databaseSource, timeout: cfg.QueryTimeout()}, nil
+}
+
+// bound limits the queries of one store call by the query timeout as well
+// as by ctx. The driver reports a query cancelled on the server as a
+// server error, so done replaces *err with the error of the context when
+// the context has ended.
+func (p *Postgres) bound(ctx context.Context, err *error) (context.Context, func()) {
+	cancel := func() {}
+	if p.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+	}
+	return ctx, func() {
+		if *err != nil && ctx.Err() != nil {
+			*err = ctx.Err()
+		}
+		cancel()
+	}
 }
@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBound(t *testing.T) {
+	driverErr := errors.New("pq: canceling statement due to user request")
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+		err     error
+		want    error
+	}{
+		{name: "given the query timed out should return deadline exceeded", timeout: time.Nanosecond, ctx: func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }, err: driverErr, want: context.DeadlineExceeded},
+		{name: "given the request was cancelled should return canceled", ctx: func() (context.Context, context.CancelFunc) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			return ctx, cancel
+		}, err: driverErr, want: context.Canceled},
+		{name: "given the query failed in time should keep its error", timeout: time.Minute, ctx: func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }, err: driverErr, want: driverErr},
+		{name: "given the query succeeded should return no error", timeout: time.Nanosecond, ctx: func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) }, err: nil, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parent, cancel := tt.ctx()
+			defer cancel()
+			p := &Postgres{timeout: tt.timeout}
+
+			err := func() (err error) {
+				_, done := p.bound(parent, &err)
+				defer done()
+				time.Sleep(time.Millisecond)
+				return tt.err
+			}()
+
+			if !errors.Is(err, tt.want) {
+				t.Errorf("expected error %v but got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/connapotae/assessment-tax/profile"
+	"github.com/connapotae/assessment-tax/tax"
+	"github.com/lib/pq"
+)
+
+// uniqueViolation is the SQLSTATE of an insert or update that breaks a
+// unique constraint.
+const uniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+func scanProfile(row interface{ Scan(...any) error }) (tax.Profile, error) {
+	var p tax.Profile
+	var allowances []byte
+	err := row.Scan(
+		&p.ID,
+		&p.TaxID,
+		&p.Name,
+		&allowances,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, tax.ErrProfileNotFound
+	}
+	if err != nil {
+		return p, err
+	}
+	err = json.Unmarshal(allowances, &p.Allowances)
+	return p, err
+}
+
+const profileColumns = `id, tax_id, name, allowances, created_at, updated_at`
+
+func (p *Postgres) CreateProfile(ctx context.Context, pr tax.Profile) (_ tax.Profile, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	allowances, err := json.Marshal(pr.Allowances)
+	if err != nil {
+		return pr, err
+	}
+	row := p.Db.QueryRowContext(ctx, `INSERT INTO taxpayer_profile (id, tax_id, name, allowances) VALUES ($1, $2, $3, $4) RETURNING `+profileColumns,
+		pr.ID, pr.TaxID, pr.Name, allowances)
+	pr, err = scanProfile(row)
+	if isUniqueViolation(err) {
+		return pr, profile.ErrDuplicate
+	}
+	return pr, err
+}
+
+func (p *Postgres) GetProfile(ctx context.Context, id string) (_ tax.Profile, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	row := p.Db.QueryRowContext(ctx, `select `+profileColumns+` from taxpayer_profile where id = $1`, id)
+	return scanProfile(row)
+}
+
+func (p *Postgres) UpdateProfile(ctx context.Context, pr tax.Profile) (_ tax.Profile, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	allowances, err := json.Marshal(pr.Allowances)
+	if err != nil {
+		return pr, err
+	}
+	row := p.Db.QueryRowContext(ctx, `UPDATE taxpayer_profile SET tax_id = $2, name = $3, allowances = $4, updated_at = now() WHERE id = $1 RETURNING `+profileColumns,
+		pr.ID, pr.TaxID, pr.Name, allowances)
+	pr, err = scanProfile(row)
+	if isUniqueViolation(err) {
+		return pr, profile.ErrDuplicate
+	}
+	return pr, err
+}
+
+func (p *Postgres) DeleteProfile(ctx context.Context, id string) (err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	res, err := p.Db.ExecContext(ctx, "DELETE FROM taxpayer_profile WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return tax.ErrProfileNotFound
+	}
+	return nil
+}
@@ -1,16 +1,29 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"math"
+	"time"
 
+	"github.com/connapotae/assessment-tax/admin"
 	"github.com/connapotae/assessment-tax/tax"
 )
 
-func (p *Postgres) GetTaxLevels() ([]tax.TBTaxLevel, error) {
-	var rows *sql.Rows
-	var err error
-	sql := `select level, label, min_amount, max_amount, tax_percent from tax_level`
-	rows, err = p.Db.Query(sql)
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func (p *Postgres) GetTaxLevels(ctx context.Context) (_ []tax.TBTaxLevel, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	return readTaxLevels(ctx, p.Db, `select level, label, label_en, min_amount, max_amount, tax_percent from tax_level order by level`)
+}
+
+func readTaxLevels(ctx context.Context, q querier, query string) ([]tax.TBTaxLevel, error) {
+	rows, err := q.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -22,6 +35,7 @@ func (p *Postgres) GetTaxLevels() ([]tax.TBTaxLevel, error) {
 		err := rows.Scan(
 			&l.Level,
 			&l.Label,
+			&l.LabelEn,
 			&l.MinAmount,
 			&l.MaxAmount,
 			&l.TaxPercent,
@@ -29,22 +43,58 @@ func (p *Postgres) GetTaxLevels() ([]tax.TBTaxLevel, error) {
 		if err != nil {
 			return nil, err
 		}
-		levels = append(levels, tax.TBTaxLevel{
-			Level:      l.Level,
-			Label:      l.Label,
-			MinAmount:  l.MinAmount,
-			MaxAmount:  l.MaxAmount,
-			TaxPercent: l.TaxPercent,
-		})
+		levels = append(levels, l)
+	}
+	return levels, rows.Err()
+}
+
+// ReplaceTaxLevels replaces every tax level with levels and records the
+// change in the audit log in one transaction, so a calculation never sees
+// a mix of old and new levels. An infinite MaxAmount is stored as the
+// open-ended top level.
+func (p *Postgres) ReplaceTaxLevels(ctx context.Context, levels []tax.TBTaxLevel, change admin.Change) (err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	tx, err := p.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	old, err := readTaxLevels(ctx, tx, `select level, label, label_en, min_amount, max_amount, tax_percent from tax_level order by level for update`)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tax_level`); err != nil {
+		return err
+	}
+	for _, l := range levels {
+		var max any
+		if !math.IsInf(l.MaxAmount, 1) {
+			max = l.MaxAmount
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO tax_level (level, label, label_en, min_amount, max_amount, tax_percent) VALUES ($1, $2, $3, $4, COALESCE($5::numeric, 'infinity'::numeric), $6)`,
+			l.Level, l.Label, l.LabelEn, l.MinAmount, max, l.TaxPercent)
+		if err != nil {
+			return err
+		}
+	}
+	if err := audit(ctx, tx, admin.EntityTaxLevel, "levels", admin.TaxLevelsOf(old), admin.TaxLevelsOf(levels), change); err != nil {
+		return err
 	}
-	return levels, nil
+	return tx.Commit()
 }
 
-func (p *Postgres) GetDeduct() ([]tax.TBDeduct, error) {
+// GetDeduct returns the amount of each deduction in force on the day of
+// at.
+func (p *Postgres) GetDeduct(ctx context.Context, at time.Time) (_ []tax.TBDeduct, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
 	var rows *sql.Rows
-	var err error
-	sql := `select deduct_type, deduct_amount from deduction`
-	rows, err = p.Db.Query(sql)
+	sql := `select distinct on (deduct_type) deduct_type, deduct_amount from deduction where effective_from <= $1::date order by deduct_type, effective_from desc`
+	rows, err = p.Db.QueryContext(ctx, sql, at.Format(time.DateOnly))
 	if err != nil {
 		return nil, err
 	}
@@ -69,10 +119,164 @@ func (p *Postgres) GetDeduct() ([]tax.TBDeduct, error) {
 	return deduct, nil
 }
 
-func (p *Postgres) UpdateDeductionAmount(amount float64, types string) error {
-	_, err := p.Db.Exec("UPDATE deduction SET deduct_amount = $1 WHERE deduct_type = $2", amount, types)
+// LoadRules reads every rule in one read-only transaction, so that the
+// rules are as of a single point in time.
+func (p *Postgres) LoadRules(ctx context.Context) (_ tax.Rules, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	tx, err := p.Db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return tax.Rules{}, err
+	}
+	defer tx.Rollback()
+
+	var rules tax.Rules
+	if rules.Levels, err = readTaxLevels(ctx, tx, `select level, label, label_en, min_amount, max_amount, tax_percent from tax_level order by level`); err != nil {
+		return tax.Rules{}, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `select deduct_type, deduct_amount, effective_from from deduction order by deduct_type, effective_from`)
+	if err != nil {
+		return tax.Rules{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d tax.TBDeduct
+		if err := rows.Scan(&d.DeductType, &d.DeductAmount, &d.EffectiveFrom); err != nil {
+			return tax.Rules{}, err
+		}
+		rules.Deducts = append(rules.Deducts, d)
+	}
+	if err := rows.Err(); err != nil {
+		return tax.Rules{}, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `select currency, rate from exchange_rate`)
 	if err != nil {
+		return tax.Rules{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r tax.TBExchangeRate
+		if err := rows.Scan(&r.Currency, &r.Rate); err != nil {
+			return tax.Rules{}, err
+		}
+		rules.Rates = append(rules.Rates, r)
+	}
+	if err := rows.Err(); err != nil {
+		return tax.Rules{}, err
+	}
+
+	return rules, tx.Commit()
+}
+
+// deductionChange is how a change to a deduction is kept in the audit
+// log.
+type deductionChange struct {
+	Amount        float64 `json:"amount"`
+	EffectiveFrom string  `json:"effectiveFrom"`
+}
+
+// UpdateDeductionAmount sets the amount of a deduction from the day of
+// effectiveFrom on and records the change in the audit log in the same
+// transaction. The amount in force before that day is left as it is. A
+// deduction without an amount by that day is admin.ErrDeductionNotFound.
+func (p *Postgres) UpdateDeductionAmount(ctx context.Context, amount float64, types string, effectiveFrom time.Time, change admin.Change) (err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	from := effectiveFrom.Format(time.DateOnly)
+	tx, err := p.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the rows of the deduction are locked so that changes to it are
+	// audited in the order they are made
+	var old any
+	var prev deductionChange
+	var prevFrom time.Time
+	err = tx.QueryRowContext(ctx, `SELECT deduct_amount, effective_from FROM deduction WHERE deduct_type = $1 AND effective_from <= $2::date ORDER BY effective_from DESC LIMIT 1 FOR UPDATE`, types, from).Scan(&prev.Amount, &prevFrom)
+	switch {
+	case err == nil:
+		prev.EffectiveFrom = prevFrom.Format(time.DateOnly)
+		old = prev
+	case errors.Is(err, sql.ErrNoRows):
+		return admin.ErrDeductionNotFound
+	default:
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO deduction (deduct_type, deduct_amount, effective_from) VALUES ($1, $2, $3::date) ON CONFLICT (deduct_type, effective_from) DO UPDATE SET deduct_amount = EXCLUDED.deduct_amount`, types, amount, from)
+	if err != nil {
+		return err
+	}
+	if err := audit(ctx, tx, admin.EntityDeduction, types, old, deductionChange{Amount: amount, EffectiveFrom: from}, change); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *Postgres) GetExchangeRates(ctx context.Context) (_ []tax.TBExchangeRate, err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	var rows *sql.Rows
+	sql := `select currency, rate from exchange_rate`
+	rows, err = p.Db.QueryContext(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []tax.TBExchangeRate
+	for rows.Next() {
+		var r tax.TBExchangeRate
+		err := rows.Scan(
+			&r.Currency,
+			&r.Rate,
+		)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, tax.TBExchangeRate{
+			Currency: r.Currency,
+			Rate:     r.Rate,
+		})
+	}
+
+	return rates, nil
+}
+
+// UpdateExchangeRate sets the rate of a currency and records the change
+// in the audit log in the same transaction.
+func (p *Postgres) UpdateExchangeRate(ctx context.Context, currency string, rate float64, change admin.Change) (err error) {
+	ctx, done := p.bound(ctx, &err)
+	defer done()
+
+	tx, err := p.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old any
+	var current float64
+	err = tx.QueryRowContext(ctx, "SELECT rate FROM exchange_rate WHERE currency = $1 FOR UPDATE", currency).Scan(&current)
+	switch {
+	case err == nil:
+		old = current
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO exchange_rate (currency, rate) VALUES ($1, $2) ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate", currency, rate)
+	if err != nil {
+		return err
+	}
+	if err := audit(ctx, tx, admin.EntityExchangeRate, currency, old, rate, change); err != nil {
 		return err
 	}
-	return nil
+	return tx.Commit()
 }
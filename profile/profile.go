@@ -0,0 +1,9 @@
+package profile
+
+import "errors"
+
+var ErrDuplicate = errors.New("profile already exists")
+
+type Err struct {
+	Message string `json:"message"`
+}
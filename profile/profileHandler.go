@@ -0,0 +1,122 @@
+package profile
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/connapotae/assessment-tax/dberr"
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/connapotae/assessment-tax/tax"
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	store Storer
+}
+
+type Storer interface {
+	CreateProfile(ctx context.Context, p tax.Profile) (tax.Profile, error)
+	GetProfile(ctx context.Context, id string) (tax.Profile, error)
+	UpdateProfile(ctx context.Context, p tax.Profile) (tax.Profile, error)
+	DeleteProfile(ctx context.Context, id string) error
+}
+
+func New(db Storer) *Handler {
+	return &Handler{store: db}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// bind reads the profile of the request body and validates it.
+func bind(c echo.Context, lang string) (tax.Profile, []tax.ValidateErr, error) {
+	var p tax.Profile
+	if err := c.Bind(&p); err != nil {
+		return p, nil, err
+	}
+	if p.Allowances == nil {
+		p.Allowances = []tax.Allowances{}
+	}
+	return p, p.Validate(lang), nil
+}
+
+// storeErr answers the errors of the store.
+func storeErr(c echo.Context, lang string, err error) error {
+	switch {
+	case errors.Is(err, tax.ErrProfileNotFound):
+		return c.JSON(http.StatusNotFound, Err{Message: i18n.T(lang, i18n.ProfileNotFound)})
+	case errors.Is(err, ErrDuplicate):
+		return c.JSON(http.StatusConflict, Err{Message: i18n.T(lang, i18n.ProfileExists)})
+	}
+	code, msg := dberr.Response(c, err)
+	return c.JSON(code, Err{Message: msg})
+}
+
+func (h *Handler) CreateProfileHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	p, errs, err := bind(c, lang)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, errs)
+	}
+
+	if p.ID, err = newID(); err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	p, err = h.store.CreateProfile(c.Request().Context(), p)
+	if err != nil {
+		return storeErr(c, lang, err)
+	}
+
+	c.Response().Header().Set(echo.HeaderLocation, c.Request().URL.Path+"/"+p.ID)
+	return c.JSON(http.StatusCreated, p)
+}
+
+func (h *Handler) GetProfileHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	p, err := h.store.GetProfile(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return storeErr(c, lang, err)
+	}
+
+	return c.JSON(http.StatusOK, p)
+}
+
+func (h *Handler) UpdateProfileHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	p, errs, err := bind(c, lang)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, errs)
+	}
+
+	p.ID = c.Param("id")
+	p, err = h.store.UpdateProfile(c.Request().Context(), p)
+	if err != nil {
+		return storeErr(c, lang, err)
+	}
+
+	return c.JSON(http.StatusOK, p)
+}
+
+func (h *Handler) DeleteProfileHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	if err := h.store.DeleteProfile(c.Request().Context(), c.Param("id")); err != nil {
+		return storeErr(c, lang, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
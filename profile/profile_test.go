@@ -0,0 +1,150 @@
+package profile
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/connapotae/assessment-tax/tax"
+	"github.com/labstack/echo/v4"
+)
+
+type StubProfile struct {
+	profiles map[string]tax.Profile
+}
+
+func (s *StubProfile) CreateProfile(ctx context.Context, p tax.Profile) (tax.Profile, error) {
+	for _, v := range s.profiles {
+		if v.TaxID == p.TaxID {
+			return p, ErrDuplicate
+		}
+	}
+	s.profiles[p.ID] = p
+	return p, nil
+}
+
+func (s *StubProfile) GetProfile(ctx context.Context, id string) (tax.Profile, error) {
+	p, ok := s.profiles[id]
+	if !ok {
+		return p, tax.ErrProfileNotFound
+	}
+	return p, nil
+}
+
+func (s *StubProfile) UpdateProfile(ctx context.Context, p tax.Profile) (tax.Profile, error) {
+	if _, ok := s.profiles[p.ID]; !ok {
+		return p, tax.ErrProfileNotFound
+	}
+	s.profiles[p.ID] = p
+	return p, nil
+}
+
+func (s *StubProfile) DeleteProfile(ctx context.Context, id string) error {
+	if _, ok := s.profiles[id]; !ok {
+		return tax.ErrProfileNotFound
+	}
+	delete(s.profiles, id)
+	return nil
+}
+
+func TestProfile(t *testing.T) {
+	existing := tax.Profile{ID: "p1", TaxID: "1101700230708", Name: "Somchai"}
+
+	tests := []struct {
+		name    string
+		method  string
+		id      string
+		req     string
+		handler func(*Handler) echo.HandlerFunc
+		want    int
+	}{
+		{
+			name:    "given user able to create profile should return 201",
+			method:  http.MethodPost,
+			req:     `{ "taxId": "3105500123452", "name": "Jane", "allowances": [{ "allowanceType": "k-receipt", "amount": 50000.0 }] }`,
+			handler: func(h *Handler) echo.HandlerFunc { return h.CreateProfileHandler },
+			want:    http.StatusCreated,
+		},
+		{
+			name:    "given profile with invalid tax id should return 400",
+			method:  http.MethodPost,
+			req:     `{ "taxId": "3105500123450", "name": "Jane" }`,
+			handler: func(h *Handler) echo.HandlerFunc { return h.CreateProfileHandler },
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "given profile with negative allowance should return 400",
+			method:  http.MethodPost,
+			req:     `{ "taxId": "3105500123452", "allowances": [{ "allowanceType": "k-receipt", "amount": -1.0 }] }`,
+			handler: func(h *Handler) echo.HandlerFunc { return h.CreateProfileHandler },
+			want:    http.StatusBadRequest,
+		},
+		{
+			name:    "given profile of existing tax id should return 409",
+			method:  http.MethodPost,
+			req:     `{ "taxId": "1101700230708" }`,
+			handler: func(h *Handler) echo.HandlerFunc { return h.CreateProfileHandler },
+			want:    http.StatusConflict,
+		},
+		{
+			name:    "given user able to get profile should return 200",
+			method:  http.MethodGet,
+			id:      "p1",
+			handler: func(h *Handler) echo.HandlerFunc { return h.GetProfileHandler },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "given unknown profile should return 404",
+			method:  http.MethodGet,
+			id:      "p2",
+			handler: func(h *Handler) echo.HandlerFunc { return h.GetProfileHandler },
+			want:    http.StatusNotFound,
+		},
+		{
+			name:    "given user able to update profile should return 200",
+			method:  http.MethodPut,
+			id:      "p1",
+			req:     `{ "taxId": "1101700230708", "name": "Somchai J." }`,
+			handler: func(h *Handler) echo.HandlerFunc { return h.UpdateProfileHandler },
+			want:    http.StatusOK,
+		},
+		{
+			name:    "given user able to delete profile should return 204",
+			method:  http.MethodDelete,
+			id:      "p1",
+			handler: func(h *Handler) echo.HandlerFunc { return h.DeleteProfileHandler },
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "given unknown profile to delete should return 404",
+			method:  http.MethodDelete,
+			id:      "p2",
+			handler: func(h *Handler) echo.HandlerFunc { return h.DeleteProfileHandler },
+			want:    http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(tt.method, "/tax/profiles", strings.NewReader(tt.req))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/tax/profiles/:id")
+			c.SetParamNames("id")
+			c.SetParamValues(tt.id)
+
+			store := &StubProfile{profiles: map[string]tax.Profile{existing.ID: existing}}
+			tt.handler(New(store))(c)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status code %d but got %d %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.want == http.StatusCreated && !strings.HasPrefix(rec.Header().Get(echo.HeaderLocation), "/tax/profiles/") {
+				t.Errorf("expected location of the profile but got %q", rec.Header().Get(echo.HeaderLocation))
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// FileError reports an uploaded file that cannot be processed at all,
+// such as an empty file or one without the required columns.
+type FileError struct {
+	Errs []ValidateErr
+}
+
+func (e *FileError) Error() string {
+	var msgs []string
+	for _, v := range e.Errs {
+		msgs = append(msgs, v.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (s *BatchSummary) Add(res RowResult) {
+	s.Total++
+	if res.Status == rowOK {
+		s.Succeeded++
+	} else {
+		s.Failed++
+	}
+}
+
+// detail calculates a row and renders it as requested by the client.
+func detail(t TaxCSV, r ruleset, opts Options) TaxesDetail {
+	res := calculate(t.calculation(), r, opts.Lang)
+	d := TaxesDetail{
+		Line:        t.Line,
+		Columns:     t.Columns,
+		TaxID:       t.TaxID,
+		TotalIncome: t.TotalIncome,
+		Tax:         res.Tax,
+		TaxRefund:   res.TaxRefund,
+	}
+	if opts.Detailed {
+		d.TaxLevel = res.TaxLevel
+	}
+	if opts.MaskTaxID {
+		d.TaxID = maskTaxID(d.TaxID)
+	}
+	if opts.Format {
+		d = d.withFormat()
+	}
+	return d
+}
+
+// source returns the values of the row as uploaded, with the taxpayer ID
+// masked if the client asked for it.
+func (h csvHeader) source(t TaxCSV, opts Options) []string {
+	if !opts.MaskTaxID || h.taxID < 0 || h.taxID >= len(t.Record) {
+		return t.Record
+	}
+	record := append([]string{}, t.Record...)
+	record[h.taxID] = maskTaxID(strings.TrimSpace(record[h.taxID]))
+	return record
+}
+
+// processRows evaluates the rows one by one and hands the result of each
+// to fn, skipping the results of the first skip rows. Skipped rows are
+// still validated so that duplicates are detected across a resume. The
+// calculated rows are recorded with rec, even when processing stops
+// early.
+func processRows(ctx context.Context, rows *csvRows, r ruleset, opts Options, skip int, rec *rowRecorder, fn func(RowResult) error) (err error) {
+	defer func() {
+		if ferr := rec.flush(); err == nil {
+			err = ferr
+		}
+	}()
+
+	v := newRowValidator(rows.header, opts.Lang)
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		t, errs, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			// the rest of a malformed file cannot be told apart into rows
+			if n <= skip {
+				return nil
+			}
+			return fn(RowResult{Line: errs[0].Line, Status: rowError, Errors: errs})
+		}
+
+		errs = append(errs, v.validate(t)...)
+		if n <= skip {
+			continue
+		}
+
+		res := RowResult{Line: t.Line, Status: rowOK}
+		if opts.Detailed {
+			res.Record = rows.header.source(t, opts)
+		}
+		if len(errs) > 0 {
+			res.Status = rowError
+			res.Errors = errs
+		} else {
+			d := detail(t, r, opts)
+			if err := rec.add(t, d); err != nil {
+				return err
+			}
+			res.Result = &d
+		}
+		if err := fn(res); err != nil {
+			return err
+		}
+	}
+}
+
+// InspectCSV checks the header of an uploaded file against the current
+// rules and counts its rows.
+func (h *Handler) InspectCSV(ctx context.Context, f File, opts Options) (int, error) {
+	r, err := h.loadRuleset(ctx, effectiveDate(opts.TaxYear), false)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, errs := newCSVRows(f, opts, allowanceTypes(r.deducts))
+	if len(errs) > 0 {
+		return 0, &FileError{Errs: errs}
+	}
+
+	count := 0
+	for {
+		_, _, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		count++
+		if err != nil {
+			return count, nil
+		}
+	}
+}
+
+// ProcessCSV calculates every row of an uploaded file in the background
+// of a batch job. The results of the first skip rows, which a previous
+// run already handed out, are not calculated again.
+func (h *Handler) ProcessCSV(ctx context.Context, f File, opts Options, skip int, fn func(RowResult) error) error {
+	r, err := h.loadRuleset(ctx, effectiveDate(opts.TaxYear), false)
+	if err != nil {
+		return err
+	}
+
+	rows, errs := newCSVRows(f, opts, allowanceTypes(r.deducts))
+	if len(errs) > 0 {
+		return &FileError{Errs: errs}
+	}
+	rec, err := h.rowRecorder(ctx, r, opts)
+	if err != nil {
+		return err
+	}
+	return processRows(ctx, rows, r, opts, skip, rec, fn)
+}
@@ -0,0 +1,109 @@
+package tax
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rules are every rule calculations are made with: the tax levels, each
+// amount a deduction has been set to with the day it takes effect, and
+// the exchange rates.
+type Rules struct {
+	Levels  []TBTaxLevel
+	Deducts []TBDeduct
+	Rates   []TBExchangeRate
+}
+
+// RulesLoader reads all of the rules at once, as of a single point in
+// time.
+type RulesLoader interface {
+	LoadRules(ctx context.Context) (Rules, error)
+}
+
+type rulesSnapshot struct {
+	rules    Rules
+	loadedAt time.Time
+	gen      uint64
+}
+
+// RulesCache keeps the rules in memory so that calculations do not read
+// them from the store. A snapshot is never changed once loaded; a new one
+// replaces it when the TTL passes or after Invalidate.
+type RulesCache struct {
+	loader   RulesLoader
+	ttl      time.Duration
+	snapshot atomic.Pointer[rulesSnapshot]
+	gen      atomic.Uint64
+	mu       sync.Mutex
+}
+
+func NewRulesCache(loader RulesLoader, ttl time.Duration) *RulesCache {
+	return &RulesCache{loader: loader, ttl: ttl}
+}
+
+// Invalidate drops the rules in memory, so that the next calculation
+// reads them again. It is called when an admin change has committed. A
+// load that started before the call is not kept.
+func (c *RulesCache) Invalidate() {
+	c.gen.Add(1)
+}
+
+func (c *RulesCache) fresh(s *rulesSnapshot) bool {
+	return s != nil && s.gen == c.gen.Load() && time.Since(s.loadedAt) < c.ttl
+}
+
+// current returns the rules in memory, loading them when there are none
+// or they are older than the TTL. Only one caller loads at a time; the
+// others wait and use what it loaded.
+func (c *RulesCache) current(ctx context.Context) (Rules, error) {
+	if s := c.snapshot.Load(); c.fresh(s) {
+		return s.rules, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s := c.snapshot.Load(); c.fresh(s) {
+		return s.rules, nil
+	}
+
+	s := &rulesSnapshot{loadedAt: time.Now(), gen: c.gen.Load()}
+	rules, err := c.loader.LoadRules(ctx)
+	if err != nil {
+		return Rules{}, err
+	}
+	s.rules = rules
+	c.snapshot.Store(s)
+	return rules, nil
+}
+
+// WithRulesCache makes the handler read the rules from c instead of the
+// store.
+func (h *Handler) WithRulesCache(c *RulesCache) *Handler {
+	h.rules = c
+	return h
+}
+
+// deductsAt returns the amount of each deduction in force on the day of
+// at.
+func deductsAt(schedule []TBDeduct, at time.Time) []TBDeduct {
+	day := at.Format(time.DateOnly)
+	index := make(map[string]int)
+	var deducts []TBDeduct
+	for _, d := range schedule {
+		if d.EffectiveFrom.Format(time.DateOnly) > day {
+			continue
+		}
+		i, ok := index[d.DeductType]
+		if !ok {
+			index[d.DeductType] = len(deducts)
+			deducts = append(deducts, d)
+			continue
+		}
+		if d.EffectiveFrom.After(deducts[i].EffectiveFrom) {
+			deducts[i] = d
+		}
+	}
+	return deducts
+}
@@ -0,0 +1,437 @@
+package tax
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/connapotae/assessment-tax/dberr"
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/connapotae/assessment-tax/xlsx"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	partialMode string = "partial"
+	rowOK       string = "ok"
+	rowError    string = "error"
+)
+
+const (
+	columnTaxID       string = "taxId"
+	columnTotalIncome string = "totalIncome"
+	columnWht         string = "wht"
+)
+
+// csvHeader maps the columns of an uploaded file onto TaxCSV. columns
+// are the names as uploaded and names the ones they were matched to.
+// The values of the columns in passThrough are carried into the results
+// unchanged. decimalComma tells how the amounts of the file are written.
+type csvHeader struct {
+	columns      []string
+	names        []string
+	passThrough  map[int]string
+	taxID        int
+	totalIncome  int
+	wht          int
+	allowances   map[int]string
+	ignored      []string
+	decimalComma bool
+}
+
+// parseHeader resolves the header row. Column names are matched ignoring
+// surrounding spaces and case. Columns named after a registered
+// allowance type become allowances; any other column is left out of the
+// calculation, reported back to the client and passed through to the
+// results.
+func parseHeader(record []string, types map[string]bool, lang string) (csvHeader, []ValidateErr) {
+	known := map[string]string{
+		strings.ToLower(columnTaxID):       columnTaxID,
+		strings.ToLower(columnTotalIncome): columnTotalIncome,
+		strings.ToLower(columnWht):         columnWht,
+	}
+	for t := range types {
+		known[strings.ToLower(t)] = t
+	}
+
+	h := csvHeader{columns: record, names: make([]string, len(record)), passThrough: make(map[int]string), taxID: -1, totalIncome: -1, wht: -1, allowances: make(map[int]string)}
+	for i, column := range record {
+		column = strings.TrimSpace(column)
+		name, ok := known[strings.ToLower(column)]
+		if !ok {
+			h.names[i] = column
+			h.ignored = append(h.ignored, column)
+			if column != "" {
+				h.passThrough[i] = column
+			}
+			continue
+		}
+		h.names[i] = name
+		switch {
+		case name == columnTaxID:
+			h.taxID = i
+		case name == columnTotalIncome:
+			h.totalIncome = i
+		case name == columnWht:
+			h.wht = i
+		default:
+			h.allowances[i] = name
+		}
+	}
+	if h.totalIncome < 0 {
+		return h, []ValidateErr{{Line: 1, Field: columnTotalIncome, Message: i18n.T(lang, i18n.MissingColumn, columnTotalIncome)}}
+	}
+	return h, nil
+}
+
+// column returns the 1-based position of the column a validation error
+// of field refers to, or 0 when the field is not a column of the file.
+func (h csvHeader) column(field string) int {
+	name := strings.TrimSuffix(field, " amount")
+	for i, c := range h.names {
+		if c == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func parseAmount(record []string, i int, decimalComma bool) (float64, error) {
+	if i < 0 || i >= len(record) {
+		return 0.0, nil
+	}
+	v := strings.TrimSpace(record[i])
+	if v == "" {
+		return 0.0, nil
+	}
+	return parseNumber(v, decimalComma)
+}
+
+func (h csvHeader) parseRow(record []string, line int, lang string) (TaxCSV, []ValidateErr) {
+	t := TaxCSV{Line: line, Record: append([]string{}, record...)}
+	var errs []ValidateErr
+
+	amount := func(i int) float64 {
+		v, err := parseAmount(record, i, h.decimalComma)
+		if err != nil {
+			errs = append(errs, ValidateErr{Line: line, Column: i + 1, Field: h.names[i], Message: i18n.T(lang, i18n.NotNumber)})
+		}
+		return v
+	}
+
+	if h.taxID >= 0 && h.taxID < len(record) {
+		t.TaxID = strings.TrimSpace(record[h.taxID])
+	}
+	for i, name := range h.passThrough {
+		if t.Columns == nil {
+			t.Columns = make(map[string]string)
+		}
+		if i < len(record) {
+			t.Columns[name] = record[i]
+		} else {
+			t.Columns[name] = ""
+		}
+	}
+	t.TotalIncome = amount(h.totalIncome)
+	if h.wht >= 0 {
+		t.Wht = amount(h.wht)
+	}
+	for i := 0; i < len(record); i++ {
+		if name, ok := h.allowances[i]; ok {
+			t.Allowances = append(t.Allowances, Allowances{AllowanceType: name, Amount: amount(i)})
+		}
+	}
+	return t, errs
+}
+
+// recordReader is a source of rows of an uploaded file.
+type recordReader interface {
+	Read() ([]string, error)
+	FieldPos(field int) (line, column int)
+}
+
+// csvRows iterates over the rows of an uploaded file without holding
+// more than one of them in memory.
+type csvRows struct {
+	reader recordReader
+	header csvHeader
+	lang   string
+}
+
+// File is an uploaded file of rows, either CSV or an XLSX workbook.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// openRecords tells a workbook from a CSV file by its content and
+// returns a reader of its rows. opts.Sheet selects the sheet of a
+// workbook; the first one is read when it is empty.
+func openRecords(f File, opts Options) (recordReader, []ValidateErr) {
+	head := make([]byte, 4)
+	n, _ := f.ReadAt(head, 0)
+	if !xlsx.IsWorkbook(head[:n]) {
+		return csvDialect(f, opts)
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, []ValidateErr{parseErr(err, opts.Lang)}
+	}
+	reader, err := xlsx.NewReader(f, size, opts.Sheet)
+	if errors.Is(err, xlsx.ErrSheetNotFound) {
+		return nil, []ValidateErr{{Message: i18n.T(opts.Lang, i18n.SheetNotFound, opts.Sheet)}}
+	}
+	if err != nil {
+		return nil, []ValidateErr{parseErr(err, opts.Lang)}
+	}
+	return reader, nil
+}
+
+// newCSVRows reads the header of f and resolves it against the
+// registered allowance types.
+func newCSVRows(f File, opts Options, types map[string]bool) (*csvRows, []ValidateErr) {
+	lang := opts.Lang
+	if !validTaxYear(opts.TaxYear) {
+		return nil, []ValidateErr{{Field: "taxYear", Message: i18n.T(lang, i18n.InvalidTaxYear)}}
+	}
+	reader, errs := openRecords(f, opts)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	record, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, []ValidateErr{{Message: i18n.T(lang, i18n.EmptyFile)}}
+	}
+	if err != nil {
+		return nil, []ValidateErr{parseErr(err, lang)}
+	}
+	header, errs := parseHeader(append([]string{}, record...), types, lang)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if cr, ok := reader.(*csv.Reader); ok {
+		header.decimalComma = decimalComma(cr.Comma)
+	}
+	return &csvRows{reader: reader, header: header, lang: lang}, nil
+}
+
+// next returns the following row and the errors of values that are not
+// numbers. It returns io.EOF after the last row; a malformed file stops
+// the iteration with errMalformed.
+func (rows *csvRows) next() (TaxCSV, []ValidateErr, error) {
+	record, err := rows.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return TaxCSV{}, nil, io.EOF
+	}
+	if err != nil {
+		return TaxCSV{}, []ValidateErr{parseErr(err, rows.lang)}, errMalformed
+	}
+	line, _ := rows.reader.FieldPos(0)
+	t, errs := rows.header.parseRow(record, line, rows.lang)
+	return t, errs, nil
+}
+
+var errMalformed = errors.New("malformed file")
+
+func parseErr(err error, lang string) ValidateErr {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return ValidateErr{Line: pe.Line, Column: pe.Column, Message: fmt.Sprintf("%s: %v", i18n.T(lang, i18n.MalformedCSV), pe.Err)}
+	}
+	if errors.Is(err, xlsx.ErrFormat) {
+		return ValidateErr{Message: fmt.Sprintf("%s: %v", i18n.T(lang, i18n.MalformedXLSX), err)}
+	}
+	return ValidateErr{Message: fmt.Sprintf("%s: %v", i18n.T(lang, i18n.MalformedCSV), err)}
+}
+
+// rowValidator validates the rows of one file and rejects taxpayer IDs
+// that appear more than once in it.
+type rowValidator struct {
+	header csvHeader
+	taxIDs map[string]int
+	lang   string
+}
+
+func newRowValidator(header csvHeader, lang string) *rowValidator {
+	return &rowValidator{header: header, taxIDs: make(map[string]int), lang: lang}
+}
+
+func (v *rowValidator) validate(t TaxCSV) []ValidateErr {
+	var errs []ValidateErr
+	for _, e := range t.validate(v.lang) {
+		e.Line = t.Line
+		e.Column = v.header.column(e.Field)
+		errs = append(errs, e)
+	}
+	if t.TaxID == "" {
+		return errs
+	}
+	if line, ok := v.taxIDs[t.TaxID]; ok {
+		return append(errs, ValidateErr{Line: t.Line, Column: v.header.taxID + 1, Field: columnTaxID, Message: i18n.T(v.lang, i18n.DuplicateOf, line)})
+	}
+	v.taxIDs[t.TaxID] = t.Line
+	return errs
+}
+
+func (t TaxCSV) calculation() TaxCalcualtions {
+	return TaxCalcualtions{
+		TaxID:       t.TaxID,
+		TotalIncome: t.TotalIncome,
+		Wht:         t.Wht,
+		Allowances:  t.Allowances,
+	}
+}
+
+func (t TaxCSV) validate(lang string) []ValidateErr {
+	return t.calculation().validate(lang)
+}
+
+// validateFile runs through every row of f and returns all of their
+// errors. It is the first pass of an all-or-nothing upload.
+func validateFile(f File, opts Options, types map[string]bool) []ValidateErr {
+	rows, errs := newCSVRows(f, opts, types)
+	if len(errs) > 0 {
+		return errs
+	}
+
+	v := newRowValidator(rows.header, opts.Lang)
+	for {
+		t, rowErrs, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			return errs
+		}
+		errs = append(errs, rowErrs...)
+		if err != nil {
+			return errs
+		}
+		errs = append(errs, v.validate(t)...)
+	}
+}
+
+func (h *Handler) TaxCalculationsCSVHandler(c echo.Context) error {
+	opts := OptionsFrom(c)
+	lang := opts.Lang
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	defer f.Close()
+
+	r, err := h.loadRuleset(c.Request().Context(), effectiveDate(opts.TaxYear), false)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	types := allowanceTypes(r.deducts)
+
+	if c.QueryParam("dryRun") == "true" {
+		rows, errs := newCSVRows(f, opts, types)
+		if len(errs) > 0 {
+			return c.JSON(http.StatusBadRequest, ValidateCSVErr{Message: i18n.T(lang, i18n.InvalidDataFile), Data: errs})
+		}
+		return c.JSON(http.StatusOK, dryRun(rows, r, lang))
+	}
+
+	format := TableFormat(c)
+	opts.Detailed = format != ""
+	rec, err := h.rowRecorder(c.Request().Context(), r, opts)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+
+	if c.QueryParam("mode") == partialMode {
+		rows, errs := newCSVRows(f, opts, types)
+		if len(errs) > 0 {
+			return c.JSON(http.StatusBadRequest, ValidateCSVErr{Message: i18n.T(lang, i18n.InvalidDataFile), Data: errs})
+		}
+		if format != "" {
+			return streamTable(c, format, rows, r, opts, rec, true)
+		}
+		return streamPartial(c, rows, r, opts, rec)
+	}
+
+	if errs := validateFile(f, opts, types); len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, ValidateCSVErr{Message: i18n.T(lang, i18n.InvalidDataFile), Data: errs})
+	}
+
+	rows, errs := newCSVRows(f, opts, types)
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, ValidateCSVErr{Message: i18n.T(lang, i18n.InvalidDataFile), Data: errs})
+	}
+	if format != "" {
+		return streamTable(c, format, rows, r, opts, rec, false)
+	}
+	return streamTaxes(c, rows, r, opts, rec)
+}
+
+// streamTaxes writes the result of every row as soon as it is computed.
+// The rows have already been validated and r loaded, so nothing should
+// fail once the status is sent. The first row is still read before that,
+// so that a file which no longer parses is answered with 400; a row that
+// fails later ends the response early rather than being calculated.
+func streamTaxes(c echo.Context, rows *csvRows, r ruleset, opts Options, rec *rowRecorder) error {
+	t, errs, err := rows.next()
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, ValidateCSVErr{Message: i18n.T(opts.Lang, i18n.InvalidDataFile), Data: errs})
+	}
+
+	s := NewResultStream(c, "taxes")
+	for ; !errors.Is(err, io.EOF); t, errs, err = rows.next() {
+		if err != nil || len(errs) > 0 {
+			return errMalformed
+		}
+		d := detail(t, r, opts)
+		if err := rec.add(t, d); err != nil {
+			return err
+		}
+		if err := s.Write(d); err != nil {
+			return err
+		}
+	}
+	if err := rec.flush(); err != nil {
+		return err
+	}
+	return s.Close(Field{"ignoredColumns", rows.header.ignored})
+}
+
+// streamPartial calculates the valid rows and reports the errors of the
+// invalid ones instead of rejecting the whole file.
+func streamPartial(c echo.Context, rows *csvRows, r ruleset, opts Options, rec *rowRecorder) error {
+	s := NewResultStream(c, "results")
+
+	var summary BatchSummary
+	err := processRows(c.Request().Context(), rows, r, opts, 0, rec, func(res RowResult) error {
+		summary.Add(res)
+		return s.Write(res)
+	})
+	if err != nil {
+		return err
+	}
+	return s.Close(Field{"summary", summary}, Field{"ignoredColumns", rows.header.ignored})
+}
+
+// streamTable writes the results as a spreadsheet of format, with the
+// status of every row when withStatus is set.
+func streamTable(c echo.Context, format string, rows *csvRows, r ruleset, opts Options, rec *rowRecorder, withStatus bool) error {
+	t, err := NewTable(c, format, "taxes", rows.header.columns, levelLabels(r, opts.Lang), withStatus)
+	if err != nil {
+		return err
+	}
+	if err := processRows(c.Request().Context(), rows, r, opts, 0, rec, t.Write); err != nil {
+		return err
+	}
+	return t.Close()
+}
@@ -0,0 +1,196 @@
+package tax
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/connapotae/assessment-tax/i18n"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// sampleSize is how much of a CSV file is looked at to detect its
+// encoding and delimiter.
+const sampleSize = 64 * 1024
+
+// delimiters are the separators detected in a CSV file, in the order
+// they are preferred when a header line has as many of each.
+var delimiters = []rune{',', ';', '\t', '|'}
+
+// csvDialect opens f as CSV. The encoding and delimiter the client
+// declared are used as given; otherwise a file that is not valid UTF-8
+// is read as Windows-874 (a superset of TIS-620) and the delimiter is
+// the one the header line is split by most. A byte order mark is always
+// dropped.
+func csvDialect(f File, opts Options) (*csv.Reader, []ValidateErr) {
+	sample := make([]byte, sampleSize)
+	n, _ := f.ReadAt(sample, 0)
+	sample = sample[:n]
+
+	var enc encoding.Encoding = unicode.UTF8
+	switch {
+	case opts.Encoding != "":
+		e, err := htmlindex.Get(opts.Encoding)
+		if err != nil {
+			return nil, []ValidateErr{{Field: "encoding", Message: i18n.T(opts.Lang, i18n.EncodingNotSupport, opts.Encoding)}}
+		}
+		enc = e
+	case !validUTF8(sample):
+		enc = charmap.Windows874
+	}
+	decoder := unicode.BOMOverride(enc.NewDecoder())
+
+	comma := ','
+	if opts.Delimiter != "" {
+		r, size := utf8.DecodeRuneInString(opts.Delimiter)
+		if size != len(opts.Delimiter) || !validDelimiter(r) {
+			return nil, []ValidateErr{{Field: "delimiter", Message: i18n.T(opts.Lang, i18n.DelimiterNotSupport, opts.Delimiter)}}
+		}
+		comma = r
+	} else {
+		decoded, _, _ := transform.Bytes(decoder, sample)
+		comma = detectDelimiter(decoded)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, []ValidateErr{parseErr(err, opts.Lang)}
+	}
+	reader := csv.NewReader(transform.NewReader(f, decoder))
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	return reader, nil
+}
+
+// validUTF8 is utf8.Valid that tolerates a character cut off at the end
+// of a sample.
+func validUTF8(b []byte) bool {
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			return !utf8.FullRune(b)
+		}
+		b = b[size:]
+	}
+	return true
+}
+
+func validDelimiter(r rune) bool {
+	return r != '"' && r != '\r' && r != '\n' && r != '\uFEFF' && r != utf8.RuneError
+}
+
+// detectDelimiter returns the delimiter that occurs most often outside
+// quotes in the first line of sample.
+func detectDelimiter(sample []byte) rune {
+	if i := bytes.IndexByte(sample, '\n'); i >= 0 {
+		sample = sample[:i]
+	}
+	counts := make(map[rune]int)
+	quoted := false
+	for _, r := range string(sample) {
+		if r == '"' {
+			quoted = !quoted
+			continue
+		}
+		if !quoted {
+			counts[r]++
+		}
+	}
+
+	best := delimiters[0]
+	for _, d := range delimiters[1:] {
+		if counts[d] > counts[best] {
+			best = d
+		}
+	}
+	return best
+}
+
+// parseNumber reads an amount written the way people type it: with
+// thousands separators, spaces, a baht sign, Thai digits or in
+// parentheses for a negative number. A comma is a thousands separator
+// unless decimalComma is set, as it is for files that use one.
+func parseNumber(s string, decimalComma bool) (float64, error) {
+	v := strings.Map(func(r rune) rune {
+		switch {
+		case r >= '๐' && r <= '๙':
+			return '0' + (r - '๐')
+		case r == ' ' || r == '\u00A0' || r == '\u202F' || r == '฿' || r == '\'':
+			return -1
+		}
+		return r
+	}, s)
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "THB"), "บาท")
+
+	negative := strings.HasPrefix(v, "(") && strings.HasSuffix(v, ")")
+	if negative {
+		v = v[1 : len(v)-1]
+	}
+
+	v, ok := normalizeSeparators(v, decimalComma)
+	if !ok {
+		return 0.0, strconv.ErrSyntax
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0.0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0.0, strconv.ErrSyntax
+	}
+	if negative {
+		f = -f
+	}
+	return f, nil
+}
+
+// decimalComma reports whether the amounts of a file delimited by comma
+// are likely written with a decimal comma. Spreadsheets export such
+// files with semicolons, since the comma is taken by the numbers.
+func decimalComma(comma rune) bool {
+	return comma == ';'
+}
+
+// normalizeSeparators turns the thousands and decimal separators of v
+// into the form strconv understands. Commas are thousands separators and
+// must each be followed by three digits, so that a mistyped amount such
+// as "12,5000" is rejected rather than misread. With decimalComma, the
+// separator that comes last is the decimal one when both a comma and a
+// period are used, and a lone comma is a decimal separator unless
+// exactly three digits follow it.
+func normalizeSeparators(v string, decimalComma bool) (string, bool) {
+	comma := strings.LastIndex(v, ",")
+	period := strings.LastIndex(v, ".")
+	switch {
+	case comma < 0:
+		return v, true
+	case decimalComma && period > comma:
+		return strings.ReplaceAll(v, ",", ""), true
+	case decimalComma && (period >= 0 || strings.Count(v, ",") == 1 && len(v)-comma-1 != 3):
+		return strings.Replace(strings.ReplaceAll(v, ".", ""), ",", ".", 1), true
+	case decimalComma:
+		return strings.ReplaceAll(v, ",", ""), true
+	case period >= 0 && period < comma:
+		return v, false
+	}
+
+	whole, _, _ := strings.Cut(v, ".")
+	groups := strings.Split(whole, ",")
+	if groups[0] == "" {
+		return v, false
+	}
+	for _, g := range groups[1:] {
+		if len(g) != 3 {
+			return v, false
+		}
+	}
+	return strings.ReplaceAll(v, ",", ""), true
+}
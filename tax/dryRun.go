@@ -0,0 +1,74 @@
+package tax
+
+import (
+	"errors"
+	"io"
+
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/connapotae/assessment-tax/money"
+)
+
+// topRate is the highest tax percent of the rules. Withholding more than
+// that share of the income is most likely a typing mistake.
+func (r ruleset) topRate() int {
+	top := 0
+	for _, l := range r.levels {
+		if l.TaxPercent > top {
+			top = l.TaxPercent
+		}
+	}
+	return top
+}
+
+// warnings reports the values of a valid row that will be calculated
+// differently from what the file says or that look suspicious.
+func (r ruleset) warnings(t TaxCSV, h csvHeader, lang string) []ValidateErr {
+	var warns []ValidateErr
+	for _, a := range t.Allowances {
+		if limit := r.deducts[a.AllowanceType]; a.Amount > limit {
+			warns = append(warns, ValidateErr{
+				Line:    t.Line,
+				Column:  h.column(a.AllowanceType),
+				Field:   a.AllowanceType,
+				Message: i18n.T(lang, i18n.AboveCap, money.Format(limit)),
+			})
+		}
+	}
+
+	if top := r.topRate(); t.Wht > t.TotalIncome*float64(top)/100 {
+		warns = append(warns, ValidateErr{
+			Line:    t.Line,
+			Column:  h.wht + 1,
+			Field:   columnWht,
+			Message: i18n.T(lang, i18n.WhtAboveRate, top),
+		})
+	}
+	return warns
+}
+
+// dryRun validates every row of a file against the rules and collects
+// the errors and warnings of all of them without calculating any tax.
+func dryRun(rows *csvRows, r ruleset, lang string) DryRun {
+	res := DryRun{Errors: []ValidateErr{}, Warnings: []ValidateErr{}, IgnoredColumns: rows.header.ignored}
+	v := newRowValidator(rows.header, lang)
+	for {
+		t, errs, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		res.Rows++
+		if err != nil {
+			res.Errors = append(res.Errors, errs...)
+			break
+		}
+
+		errs = append(errs, v.validate(t)...)
+		if len(errs) > 0 {
+			res.Errors = append(res.Errors, errs...)
+			continue
+		}
+		res.Warnings = append(res.Warnings, r.warnings(t, rows.header, lang)...)
+	}
+	res.Valid = len(res.Errors) == 0
+	return res
+}
@@ -0,0 +1,107 @@
+package tax
+
+import (
+	"strconv"
+
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/connapotae/assessment-tax/money"
+	"github.com/labstack/echo/v4"
+)
+
+// formatRequested reports whether the client opted in to formatted money
+// fields with ?format=true.
+func formatRequested(c echo.Context) bool {
+	return c.QueryParam("format") == "true"
+}
+
+// Options are the settings a client chooses for the rendering of a
+// calculation. Detailed results keep the values of the source row and the
+// tax of each level, as needed to render them as a spreadsheet.
+type Options struct {
+	Lang      string `json:"lang"`
+	MaskTaxID bool   `json:"maskTaxId"`
+	Format    bool   `json:"format"`
+	Detailed  bool   `json:"detailed"`
+	Sheet     string `json:"sheet,omitempty"`
+	Encoding  string `json:"encoding,omitempty"`
+	Delimiter string `json:"delimiter,omitempty"`
+	TaxYear   int    `json:"taxYear,omitempty"`
+	// Client is the address the file was uploaded from, as recorded in
+	// the history of its rows.
+	Client string `json:"client,omitempty"`
+}
+
+func OptionsFrom(c echo.Context) Options {
+	return Options{
+		Lang:      i18n.Lang(c),
+		MaskTaxID: c.QueryParam("maskTaxId") == "true",
+		Format:    formatRequested(c),
+		Sheet:     c.QueryParam("sheet"),
+		Encoding:  c.QueryParam("encoding"),
+		Delimiter: c.QueryParam("delimiter"),
+		TaxYear:   taxYearParam(c.QueryParam("taxYear")),
+		Client:    c.RealIP(),
+	}
+}
+
+// taxYearParam reads the taxYear query parameter. A value that is not a
+// number is returned as -1 so that it fails validation.
+func taxYearParam(v string) int {
+	if v == "" {
+		return 0
+	}
+	y, err := strconv.Atoi(v)
+	if err != nil {
+		return -1
+	}
+	return y
+}
+
+func (t Tax) withFormat() Tax {
+	t.Formatted = map[string]money.Amount{
+		"tax": money.New(t.Tax),
+	}
+	if t.TaxRefund != 0 {
+		t.Formatted["taxRefund"] = money.New(t.TaxRefund)
+	}
+	if t.ForeignTaxCredit != 0 {
+		t.Formatted["foreignTaxCredit"] = money.New(t.ForeignTaxCredit)
+	}
+
+	levels := make([]TaxLevel, len(t.TaxLevel))
+	for i, l := range t.TaxLevel {
+		l.Formatted = map[string]money.Amount{"tax": money.New(l.Tax)}
+		levels[i] = l
+	}
+	t.TaxLevel = levels
+	return t
+}
+
+func (d TaxesDetail) withFormat() TaxesDetail {
+	d.Formatted = map[string]money.Amount{
+		"totalIncome": money.New(d.TotalIncome),
+		"tax":         money.New(d.Tax),
+	}
+	if d.TaxRefund != 0 {
+		d.Formatted["taxRefund"] = money.New(d.TaxRefund)
+	}
+	return d
+}
+
+func (s SeparateFiling) withFormat() SeparateFiling {
+	s.Taxpayer = s.Taxpayer.withFormat()
+	s.Spouse = s.Spouse.withFormat()
+	s.Formatted = map[string]money.Amount{
+		"tax": money.New(s.Tax),
+	}
+	if s.TaxRefund != 0 {
+		s.Formatted["taxRefund"] = money.New(s.TaxRefund)
+	}
+	return s
+}
+
+func (hh HouseholdTax) withFormat() HouseholdTax {
+	hh.Separate = hh.Separate.withFormat()
+	hh.Joint = hh.Joint.withFormat()
+	return hh
+}
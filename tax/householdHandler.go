@@ -0,0 +1,155 @@
+package tax
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/connapotae/assessment-tax/dberr"
+	"github.com/connapotae/assessment-tax/history"
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	separateFiling string = "separate"
+	jointFiling    string = "joint"
+)
+
+func (hh Household) validate(lang string) []ValidateErr {
+	var errs []ValidateErr
+	for _, e := range hh.Taxpayer.validate(lang) {
+		errs = append(errs, ValidateErr{Field: "taxpayer." + e.Field, Message: e.Message})
+	}
+	for _, e := range hh.Spouse.validate(lang) {
+		errs = append(errs, ValidateErr{Field: "spouse." + e.Field, Message: e.Message})
+	}
+	if hh.Taxpayer.TaxYear != 0 && hh.Spouse.TaxYear != 0 && hh.Taxpayer.TaxYear != hh.Spouse.TaxYear {
+		errs = append(errs, ValidateErr{Field: "spouse.taxYear", Message: i18n.T(lang, i18n.TaxYearMismatch)})
+	}
+	return errs
+}
+
+// taxYear is the tax year of the household, given on either spouse.
+func (hh Household) taxYear() int {
+	if hh.Taxpayer.TaxYear != 0 {
+		return hh.Taxpayer.TaxYear
+	}
+	return hh.Spouse.TaxYear
+}
+
+// combine merges both spouses into a single joint return. Allowances of
+// the same type are summed so that the per-type cap applies once to the
+// household.
+func (hh Household) combine() TaxCalcualtions {
+	joint := TaxCalcualtions{
+		TotalIncome: hh.Taxpayer.TotalIncome + hh.Spouse.TotalIncome,
+		Wht:         hh.Taxpayer.Wht + hh.Spouse.Wht,
+	}
+
+	index := make(map[string]int)
+	for _, a := range append(append([]Allowances{}, hh.Taxpayer.Allowances...), hh.Spouse.Allowances...) {
+		if i, ok := index[a.AllowanceType]; ok {
+			joint.Allowances[i].Amount += a.Amount
+			continue
+		}
+		index[a.AllowanceType] = len(joint.Allowances)
+		joint.Allowances = append(joint.Allowances, a)
+	}
+
+	joint.ForeignIncomes = append(joint.ForeignIncomes, hh.Taxpayer.ForeignIncomes...)
+	joint.ForeignIncomes = append(joint.ForeignIncomes, hh.Spouse.ForeignIncomes...)
+	return joint
+}
+
+// jointRuleset returns the rules of a joint return: each spouse keeps
+// their own personal deduction, every other cap is shared.
+func jointRuleset(r ruleset) ruleset {
+	deducts := make(map[string]float64, len(r.deducts))
+	for k, v := range r.deducts {
+		deducts[k] = v
+	}
+	deducts["personal"] = 2 * personalDeduct(r.deducts)
+	return ruleset{deducts: deducts, levels: r.levels, rates: r.rates}
+}
+
+func netTax(t Tax) float64 {
+	return t.Tax - t.TaxRefund
+}
+
+func calculateHousehold(hh Household, r ruleset, lang string) HouseholdTax {
+	taxpayer := calculate(hh.Taxpayer, r, lang)
+	spouse := calculate(hh.Spouse, r, lang)
+	joint := calculate(hh.combine(), jointRuleset(r), lang)
+
+	separate := SeparateFiling{Taxpayer: taxpayer, Spouse: spouse}
+	total := netTax(taxpayer) + netTax(spouse)
+	if total < 0 {
+		separate.TaxRefund = math.Abs(total)
+	} else {
+		separate.Tax = total
+	}
+
+	recommendation := separateFiling
+	if netTax(joint) < total {
+		recommendation = jointFiling
+	}
+
+	return HouseholdTax{
+		Separate:       separate,
+		Joint:          joint,
+		Recommendation: recommendation,
+	}
+}
+
+func (h *Handler) HouseholdCalculationsHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	var hh Household
+	if err := c.Bind(&hh); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+
+	var errs []ValidateErr
+	for _, person := range []struct {
+		field string
+		t     *TaxCalcualtions
+	}{{"taxpayer.", &hh.Taxpayer}, {"spouse.", &hh.Spouse}} {
+		t, profileErrs, err := h.applyProfile(c.Request().Context(), *person.t, lang)
+		if err != nil {
+			code, msg := dberr.Response(c, err)
+			return c.JSON(code, Err{Message: msg})
+		}
+		for _, e := range profileErrs {
+			errs = append(errs, ValidateErr{Field: person.field + e.Field, Message: e.Message})
+		}
+		*person.t = t
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, errs)
+	}
+
+	if err := hh.validate(lang); len(err) > 0 {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	r, err := h.loadRuleset(c.Request().Context(), effectiveDate(hh.taxYear()), len(hh.Taxpayer.ForeignIncomes) > 0 || len(hh.Spouse.ForeignIncomes) > 0)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+
+	if err := hh.combine().validateCurrency(r.rates, lang); len(err) > 0 {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	res := calculateHousehold(hh, r, lang)
+	if formatRequested(c) {
+		res = res.withFormat()
+	}
+
+	if err := h.record(c, history.KindHousehold, hh, res, r, hh.Taxpayer.TaxID, hh.Spouse.TaxID); err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+
+	return c.JSON(http.StatusOK, res)
+}
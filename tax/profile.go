@@ -0,0 +1,76 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/connapotae/assessment-tax/i18n"
+)
+
+var ErrProfileNotFound = errors.New("profile not found")
+
+func (p Profile) Validate(lang string) []ValidateErr {
+	var errs []ValidateErr
+	if !validTaxID(p.TaxID) {
+		errs = append(errs, ValidateErr{
+			Field:   "taxId",
+			Message: i18n.T(lang, i18n.InvalidTaxID),
+		})
+	}
+	for i, v := range p.Allowances {
+		if v.AllowanceType == "" {
+			errs = append(errs, ValidateErr{
+				Field:   fmt.Sprintf("allowances[%d].allowanceType", i),
+				Message: i18n.T(lang, i18n.NotEmpty),
+			})
+		}
+		if v.Amount < 0 {
+			errs = append(errs, ValidateErr{
+				Field:   fmt.Sprintf("allowances[%d].amount", i),
+				Message: i18n.T(lang, i18n.GtZero),
+			})
+		}
+	}
+	return errs
+}
+
+// mergeAllowances adds the standing allowances of a profile to those of a
+// request. An allowance type given in the request replaces the standing
+// one.
+func mergeAllowances(standing []Allowances, request []Allowances) []Allowances {
+	given := make(map[string]bool)
+	for _, a := range request {
+		given[a.AllowanceType] = true
+	}
+	merged := []Allowances{}
+	for _, a := range standing {
+		if !given[a.AllowanceType] {
+			merged = append(merged, a)
+		}
+	}
+	return append(merged, request...)
+}
+
+// applyProfile fills in the taxpayer ID and standing allowances of the
+// profile t refers to. Income and WHT always come from the request.
+func (h *Handler) applyProfile(ctx context.Context, t TaxCalcualtions, lang string) (TaxCalcualtions, []ValidateErr, error) {
+	if t.ProfileID == "" {
+		return t, nil, nil
+	}
+
+	p, err := h.store.GetProfile(ctx, t.ProfileID)
+	if errors.Is(err, ErrProfileNotFound) {
+		return t, []ValidateErr{{Field: "profileId", Message: i18n.T(lang, i18n.ProfileNotFound)}}, nil
+	}
+	if err != nil {
+		return t, nil, err
+	}
+
+	if t.TaxID != "" && t.TaxID != p.TaxID {
+		return t, []ValidateErr{{Field: "taxId", Message: i18n.T(lang, i18n.ProfileTaxIDMismatch)}}, nil
+	}
+	t.TaxID = p.TaxID
+	t.Allowances = mergeAllowances(p.Allowances, t.Allowances)
+	return t, nil, nil
+}
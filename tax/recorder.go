@@ -0,0 +1,147 @@
+package tax
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+
+	"github.com/connapotae/assessment-tax/history"
+	"github.com/labstack/echo/v4"
+)
+
+// Recorder keeps the calculations that were answered, for auditing.
+type Recorder interface {
+	SaveCalculation(ctx context.Context, r history.Record) error
+	SaveCalculations(ctx context.Context, records []history.Record) error
+}
+
+// WithRecorder makes the handler record every calculation it answers,
+// including each row of an uploaded file or batch job.
+func (h *Handler) WithRecorder(r Recorder) *Handler {
+	h.recorder = r
+	return h
+}
+
+// version identifies the content of the rules, so that records computed
+// with the same rules carry the same version.
+func (r ruleset) version() (string, error) {
+	// the top level ends at infinity, which JSON has no number for
+	levels := make([]TBTaxLevel, len(r.levels))
+	for i, l := range r.levels {
+		if math.IsInf(l.MaxAmount, 1) {
+			l.MaxAmount = math.MaxFloat64
+		}
+		levels[i] = l
+	}
+	b, err := json.Marshal(struct {
+		Deducts map[string]float64 `json:"deducts"`
+		Levels  []TBTaxLevel       `json:"levels"`
+		Rates   map[string]float64 `json:"rates"`
+	}{r.deducts, levels, r.rates})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:6]), nil
+}
+
+// newRecord renders a calculation as it is kept in the history.
+func newRecord(kind string, input any, result any, version string, client string, taxIDs ...string) (history.Record, error) {
+	in, err := json.Marshal(input)
+	if err != nil {
+		return history.Record{}, err
+	}
+	out, err := json.Marshal(result)
+	if err != nil {
+		return history.Record{}, err
+	}
+
+	ids := []string{}
+	for _, id := range taxIDs {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	return history.Record{
+		Kind:           kind,
+		TaxIDs:         ids,
+		Input:          in,
+		Result:         out,
+		RulesetVersion: version,
+		Client:         client,
+	}, nil
+}
+
+// record saves a calculation when a recorder is set.
+func (h *Handler) record(c echo.Context, kind string, input any, result any, r ruleset, taxIDs ...string) error {
+	if h.recorder == nil {
+		return nil
+	}
+
+	version, err := r.version()
+	if err != nil {
+		return err
+	}
+	rec, err := newRecord(kind, input, result, version, c.RealIP(), taxIDs...)
+	if err != nil {
+		return err
+	}
+	return h.recorder.SaveCalculation(c.Request().Context(), rec)
+}
+
+// recordEvery is the number of rows of a file whose records are saved
+// together.
+const recordEvery int = 500
+
+// rowRecorder saves the calculated rows of an uploaded file to the
+// history in batches. A nil rowRecorder records nothing.
+type rowRecorder struct {
+	recorder Recorder
+	ctx      context.Context
+	version  string
+	client   string
+	records  []history.Record
+}
+
+// rowRecorder returns the recorder of the rows of a file calculated with
+// r, or nil when no recorder is set.
+func (h *Handler) rowRecorder(ctx context.Context, r ruleset, opts Options) (*rowRecorder, error) {
+	if h.recorder == nil {
+		return nil, nil
+	}
+	version, err := r.version()
+	if err != nil {
+		return nil, err
+	}
+	// the rows already answered are recorded even when ctx is cancelled
+	return &rowRecorder{recorder: h.recorder, ctx: context.WithoutCancel(ctx), version: version, client: opts.Client}, nil
+}
+
+// add records the result d of the row t.
+func (rr *rowRecorder) add(t TaxCSV, d TaxesDetail) error {
+	if rr == nil {
+		return nil
+	}
+	rec, err := newRecord(history.KindCSV, t.calculation(), d, rr.version, rr.client, t.TaxID)
+	if err != nil {
+		return err
+	}
+	rr.records = append(rr.records, rec)
+	if len(rr.records) >= recordEvery {
+		return rr.flush()
+	}
+	return nil
+}
+
+// flush saves the rows added since the last flush.
+func (rr *rowRecorder) flush() error {
+	if rr == nil || len(rr.records) == 0 {
+		return nil
+	}
+	err := rr.recorder.SaveCalculations(rr.ctx, rr.records)
+	rr.records = nil
+	return err
+}
@@ -0,0 +1,102 @@
+package tax
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	mimeNDJSON string = "application/x-ndjson"
+	flushEvery int    = 100
+)
+
+// Field is a member of the object written after the streamed array.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// ResultStream writes a JSON object whose array member is sent element
+// by element, so that a batch never has to be held in memory. Clients
+// that accept NDJSON get one line per element instead, each wrapped as
+// {"type":"row","row":...}, and a last {"type":"summary",...} line that
+// holds the remaining members and marks the end of the stream.
+type ResultStream struct {
+	res    *echo.Response
+	ndjson bool
+	n      int
+}
+
+// NewResultStream starts a 200 response whose array member is named key.
+func NewResultStream(c echo.Context, key string) *ResultStream {
+	s := &ResultStream{
+		res:    c.Response(),
+		ndjson: strings.Contains(c.Request().Header.Get(echo.HeaderAccept), mimeNDJSON),
+	}
+	if s.ndjson {
+		s.res.Header().Set(echo.HeaderContentType, mimeNDJSON)
+	} else {
+		s.res.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	}
+	s.res.WriteHeader(http.StatusOK)
+	if !s.ndjson {
+		s.res.Write([]byte(`{"` + key + `":[`))
+	}
+	return s
+}
+
+func (s *ResultStream) Write(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if !s.ndjson && s.n > 0 {
+		b = append([]byte(","), b...)
+	}
+	if s.ndjson {
+		b = append(append([]byte(`{"type":"row","row":`), b...), "}\n"...)
+	}
+	if _, err := s.res.Write(b); err != nil {
+		return err
+	}
+	s.n++
+	if s.n%flushEvery == 0 {
+		s.res.Flush()
+	}
+	return nil
+}
+
+// Close ends the array and writes the remaining members of the object,
+// skipping those without a value. In NDJSON they make up the summary
+// line, which is written even without members.
+func (s *ResultStream) Close(fields ...Field) error {
+	var members []string
+	for _, f := range fields {
+		b, err := json.Marshal(f.Value)
+		if err != nil {
+			return err
+		}
+		if string(b) == "null" {
+			continue
+		}
+		members = append(members, `"`+f.Key+`":`+string(b))
+	}
+
+	var out string
+	switch {
+	case !s.ndjson && len(members) == 0:
+		out = "]}"
+	case !s.ndjson:
+		out = "]," + strings.Join(members, ",") + "}"
+	default:
+		out = `{"type":"summary"` + strings.Join(append([]string{""}, members...), ",") + "}\n"
+	}
+	if _, err := s.res.Write([]byte(out)); err != nil {
+		return err
+	}
+	s.res.Flush()
+	return nil
+}
@@ -0,0 +1,151 @@
+package tax
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/connapotae/assessment-tax/xlsx"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	MIMETextCSV string = "text/csv"
+	MIMEXLSX    string = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// TableFormat returns the spreadsheet type the client accepts, or an
+// empty string when it wants JSON.
+func TableFormat(c echo.Context) string {
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	switch {
+	case strings.Contains(accept, MIMEXLSX):
+		return MIMEXLSX
+	case strings.Contains(accept, MIMETextCSV):
+		return MIMETextCSV
+	}
+	return ""
+}
+
+type tableWriter interface {
+	Write(cells []any) error
+	Close() error
+}
+
+type csvTable struct {
+	w *csv.Writer
+}
+
+func (t csvTable) Write(cells []any) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		switch v := cell.(type) {
+		case nil:
+		case float64:
+			record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			record[i] = fmt.Sprint(v)
+		}
+	}
+	return t.w.Write(record)
+}
+
+func (t csvTable) Close() error {
+	t.w.Flush()
+	return t.w.Error()
+}
+
+// Table writes the results of a batch as a spreadsheet: the columns of
+// the uploaded file followed by the tax, the refund and the tax of each
+// level. Partial batches also get the status and errors of every row.
+type Table struct {
+	w          tableWriter
+	source     int
+	levels     int
+	withStatus bool
+}
+
+// NewTable starts a spreadsheet response of format named after filename.
+func NewTable(c echo.Context, format string, filename string, header []string, levels []string, withStatus bool) (*Table, error) {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, format)
+
+	var w tableWriter
+	if format == MIMEXLSX {
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename+".xlsx"))
+		res.WriteHeader(http.StatusOK)
+		xw, err := xlsx.NewWriter(res, "taxes")
+		if err != nil {
+			return nil, err
+		}
+		w = xw
+	} else {
+		res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename+".csv"))
+		res.WriteHeader(http.StatusOK)
+		w = csvTable{w: csv.NewWriter(res)}
+	}
+
+	t := &Table{w: w, source: len(header), levels: len(levels), withStatus: withStatus}
+	var cells []any
+	for _, h := range header {
+		cells = append(cells, h)
+	}
+	if withStatus {
+		cells = append(cells, "status", "errors")
+	}
+	cells = append(cells, "tax", "taxRefund")
+	for _, l := range levels {
+		cells = append(cells, l)
+	}
+	return t, w.Write(cells)
+}
+
+func (t *Table) Write(res RowResult) error {
+	cells := make([]any, t.source)
+	for i := 0; i < t.source && i < len(res.Record); i++ {
+		cells[i] = res.Record[i]
+	}
+	if t.withStatus {
+		var errs []string
+		for _, e := range res.Errors {
+			errs = append(errs, strings.TrimPrefix(e.Field+": "+e.Message, ": "))
+		}
+		cells = append(cells, res.Status, strings.Join(errs, "; "))
+	}
+
+	levels := make([]any, t.levels)
+	if res.Result == nil {
+		cells = append(cells, nil, nil)
+	} else {
+		cells = append(cells, res.Result.Tax, res.Result.TaxRefund)
+		for i := 0; i < t.levels && i < len(res.Result.TaxLevel); i++ {
+			levels[i] = res.Result.TaxLevel[i].Tax
+		}
+	}
+	return t.w.Write(append(cells, levels...))
+}
+
+func (t *Table) Close() error {
+	return t.w.Close()
+}
+
+// levelLabels returns the labels of the tax levels in lang, which head
+// the per-level columns of a spreadsheet.
+func levelLabels(r ruleset, lang string) []string {
+	var labels []string
+	for _, l := range r.levels {
+		labels = append(labels, l.label(lang))
+	}
+	return labels
+}
+
+// ReadHeader returns the column names of an uploaded file.
+func ReadHeader(f File, opts Options) ([]string, error) {
+	reader, errs := openRecords(f, opts)
+	if len(errs) > 0 {
+		return nil, &FileError{Errs: errs}
+	}
+	return reader.Read()
+}
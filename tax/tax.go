@@ -1,9 +1,20 @@
 package tax
 
+import (
+	"time"
+
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/connapotae/assessment-tax/money"
+)
+
 type TaxCalcualtions struct {
-	TotalIncome float64      `json:"totalIncome"`
-	Wht         float64      `json:"wht"`
-	Allowances  []Allowances `json:"allowances"`
+	ProfileID      string          `json:"profileId,omitempty"`
+	TaxID          string          `json:"taxId,omitempty"`
+	TaxYear        int             `json:"taxYear,omitempty"`
+	TotalIncome    float64         `json:"totalIncome"`
+	Wht            float64         `json:"wht"`
+	Allowances     []Allowances    `json:"allowances"`
+	ForeignIncomes []ForeignIncome `json:"foreignIncomes,omitempty"`
 }
 
 type Allowances struct {
@@ -11,31 +22,137 @@ type Allowances struct {
 	Amount        float64 `json:"amount"`
 }
 
+type ForeignIncome struct {
+	Currency   string  `json:"currency"`
+	Amount     float64 `json:"amount"`
+	ForeignTax float64 `json:"foreignTax"`
+}
+
+// TaxCSV is a row of an uploaded file. Columns other than taxId,
+// totalIncome and wht are read as allowances of the same type.
 type TaxCSV struct {
-	TotalIncome float64 `csv:"totalIncome"`
-	Wht         float64 `csv:"wht"`
-	Donation    float64 `csv:"donation"`
+	Line        int
+	Record      []string
+	Columns     map[string]string
+	TaxID       string
+	TotalIncome float64
+	Wht         float64
+	Allowances  []Allowances
 }
 
 type Tax struct {
-	Tax       float64    `json:"tax"`
-	TaxRefund float64    `json:"taxRefund,omitempty"`
-	TaxLevel  []TaxLevel `json:"taxLevel"`
+	Tax              float64                 `json:"tax"`
+	TaxRefund        float64                 `json:"taxRefund,omitempty"`
+	ForeignTaxCredit float64                 `json:"foreignTaxCredit,omitempty"`
+	TaxLevel         []TaxLevel              `json:"taxLevel"`
+	Formatted        map[string]money.Amount `json:"formatted,omitempty"`
+}
+
+type WithholdingCalculations struct {
+	TaxCalcualtions
+	Certificates []WithholdingCertificate `json:"certificates"`
+}
+
+// WithholdingCertificate is a 50 Tawi record issued by a payer.
+type WithholdingCertificate struct {
+	PayerTaxID  string  `json:"payerTaxId" csv:"payerTaxId"`
+	IncomeType  string  `json:"incomeType" csv:"incomeType"`
+	AmountPaid  float64 `json:"amountPaid" csv:"amountPaid"`
+	TaxWithheld float64 `json:"taxWithheld" csv:"taxWithheld"`
+}
+
+type WithholdingTax struct {
+	Tax
+	TotalIncome float64        `json:"totalIncome"`
+	Wht         float64        `json:"wht"`
+	Incomes     []IncomeByType `json:"incomes"`
+}
+
+type IncomeByType struct {
+	IncomeType string  `json:"incomeType"`
+	Amount     float64 `json:"amount"`
+}
+
+// Profile holds what stays the same between the calculations of one
+// taxpayer: who they are, their family and their standing allowances.
+type Profile struct {
+	ID         string       `json:"id"`
+	TaxID      string       `json:"taxId"`
+	Name       string       `json:"name"`
+	Allowances []Allowances `json:"allowances"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	UpdatedAt  time.Time    `json:"updatedAt"`
+}
+
+type Household struct {
+	Taxpayer TaxCalcualtions `json:"taxpayer"`
+	Spouse   TaxCalcualtions `json:"spouse"`
+}
+
+type HouseholdTax struct {
+	Separate       SeparateFiling `json:"separate"`
+	Joint          Tax            `json:"joint"`
+	Recommendation string         `json:"recommendation"`
+}
+
+type SeparateFiling struct {
+	Taxpayer  Tax                     `json:"taxpayer"`
+	Spouse    Tax                     `json:"spouse"`
+	Tax       float64                 `json:"tax"`
+	TaxRefund float64                 `json:"taxRefund,omitempty"`
+	Formatted map[string]money.Amount `json:"formatted,omitempty"`
 }
 
 type TaxLevel struct {
-	Level string  `json:"level"`
-	Tax   float64 `json:"tax"`
+	Level     string                  `json:"level"`
+	Tax       float64                 `json:"tax"`
+	Formatted map[string]money.Amount `json:"formatted,omitempty"`
+}
+
+// DryRun is the report of a file that was validated without calculating
+// its tax.
+type DryRun struct {
+	Rows           int           `json:"rows"`
+	Valid          bool          `json:"valid"`
+	Errors         []ValidateErr `json:"errors"`
+	Warnings       []ValidateErr `json:"warnings"`
+	IgnoredColumns []string      `json:"ignoredColumns,omitempty"`
 }
 
 type Taxes struct {
-	Taxes []TaxesDetail `json:"taxes"`
+	Taxes          []TaxesDetail `json:"taxes"`
+	IgnoredColumns []string      `json:"ignoredColumns,omitempty"`
 }
 
 type TaxesDetail struct {
-	TotalIncome float64 `json:"totalIncome"`
-	Tax         float64 `json:"tax"`
-	TaxRefund   float64 `json:"taxRefund,omitempty"`
+	Line        int                     `json:"line,omitempty"`
+	Columns     map[string]string       `json:"columns,omitempty"`
+	TaxID       string                  `json:"taxId,omitempty"`
+	TotalIncome float64                 `json:"totalIncome"`
+	Tax         float64                 `json:"tax"`
+	TaxRefund   float64                 `json:"taxRefund,omitempty"`
+	TaxLevel    []TaxLevel              `json:"taxLevel,omitempty"`
+	Formatted   map[string]money.Amount `json:"formatted,omitempty"`
+}
+
+type PartialTaxes struct {
+	Results        []RowResult  `json:"results"`
+	Summary        BatchSummary `json:"summary"`
+	IgnoredColumns []string     `json:"ignoredColumns,omitempty"`
+}
+
+type RowResult struct {
+	Line   int           `json:"line"`
+	Record []string      `json:"record,omitempty"`
+	Status string        `json:"status"`
+	Result *TaxesDetail  `json:"result,omitempty"`
+	Errors []ValidateErr `json:"errors,omitempty"`
+}
+
+type BatchSummary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
 }
 
 type Err struct {
@@ -43,6 +160,8 @@ type Err struct {
 }
 
 type ValidateErr struct {
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
 	Field   string `json:"field"`
 	Message string `json:"message"`
 }
@@ -56,13 +175,30 @@ type TBTaxLevel struct {
 	Id         int     `postgres:"id" json:"id"`
 	Level      int     `postgres:"level" json:"level"`
 	Label      string  `postgres:"label" json:"label"`
+	LabelEn    string  `postgres:"label_en" json:"labelEn"`
 	MinAmount  float64 `postgres:"min_amount" json:"minAmount"`
 	MaxAmount  float64 `postgres:"max_amount" json:"maxAmount"`
 	TaxPercent int     `postgres:"tax_percent" json:"taxPercent"`
 }
 
 type TBDeduct struct {
-	Id           int     `postgres:"id" json:"id"`
-	DeductType   string  `postgres:"deduct_type" json:"deductType"`
-	DeductAmount float64 `postgres:"deduct_amount" json:"deductAmount"`
+	Id            int       `postgres:"id" json:"id"`
+	DeductType    string    `postgres:"deduct_type" json:"deductType"`
+	DeductAmount  float64   `postgres:"deduct_amount" json:"deductAmount"`
+	EffectiveFrom time.Time `postgres:"effective_from" json:"effectiveFrom"`
+}
+
+type TBExchangeRate struct {
+	Id       int     `postgres:"id" json:"id"`
+	Currency string  `postgres:"currency" json:"currency"`
+	Rate     float64 `postgres:"rate" json:"rate"`
+}
+
+// label returns the label of the level in lang, falling back to the Thai
+// label when no translation is stored.
+func (l TBTaxLevel) label(lang string) string {
+	if lang == i18n.EN && l.LabelEn != "" {
+		return l.LabelEn
+	}
+	return l.Label
 }
@@ -1,87 +1,55 @@
 package tax
 
 import (
-	"fmt"
-	"io"
+	"context"
 	"math"
 	"net/http"
+	"strings"
+	"time"
 
-	"github.com/gocarina/gocsv"
+	"github.com/connapotae/assessment-tax/dberr"
+	"github.com/connapotae/assessment-tax/history"
+	"github.com/connapotae/assessment-tax/i18n"
 	"github.com/labstack/echo/v4"
 )
 
-const (
-	invalidRequestErr  string = "Request parameters are invalid."
-	invalidDataFileErr string = "File contains invalid data."
-)
-
 type Handler struct {
-	store Storer
+	store    Storer
+	recorder Recorder
+	rules    *RulesCache
 }
 
 type Storer interface {
-	GetTaxLevels() ([]TBTaxLevel, error)
-	GetDeduct() ([]TBDeduct, error)
+	GetTaxLevels(ctx context.Context) ([]TBTaxLevel, error)
+	GetDeduct(ctx context.Context, at time.Time) ([]TBDeduct, error)
+	GetExchangeRates(ctx context.Context) ([]TBExchangeRate, error)
+	GetProfile(ctx context.Context, id string) (Profile, error)
 }
 
 func New(db Storer) *Handler {
 	return &Handler{store: db}
 }
 
-func (t TaxCalcualtions) validate() []ValidateErr {
+func (t TaxCalcualtions) validate(lang string) []ValidateErr {
 	var errs []ValidateErr
-	gtZero := "must more than 0"
+	gtZero := i18n.T(lang, i18n.GtZero)
 
-	// totalIncome
-	if t.TotalIncome < 0 {
+	// taxId
+	if t.TaxID != "" && !validTaxID(t.TaxID) {
 		errs = append(errs, ValidateErr{
-			Field:   "totalIncome",
-			Message: gtZero,
+			Field:   "taxId",
+			Message: i18n.T(lang, i18n.InvalidTaxID),
 		})
 	}
 
-	// wht
-	if t.Wht < 0 {
+	// taxYear
+	if !validTaxYear(t.TaxYear) {
 		errs = append(errs, ValidateErr{
-			Field:   "wht",
-			Message: gtZero,
+			Field:   "taxYear",
+			Message: i18n.T(lang, i18n.InvalidTaxYear),
 		})
 	}
 
-	if t.Wht > t.TotalIncome {
-		errs = append(errs, ValidateErr{
-			Field:   "wht",
-			Message: "must less than totalIncome",
-		})
-	}
-
-	// allowances
-	for _, v := range t.Allowances {
-		switch v.AllowanceType {
-		case "donation":
-			if v.Amount < 0 {
-				errs = append(errs, ValidateErr{
-					Field:   "donation amount",
-					Message: gtZero,
-				})
-			}
-		case "k-receipt":
-			if v.Amount < 0 {
-				errs = append(errs, ValidateErr{
-					Field:   "k-receipt amount",
-					Message: gtZero,
-				})
-			}
-		}
-	}
-
-	return errs
-}
-
-func (t TaxCSV) validate() []ValidateErr {
-	var errs []ValidateErr
-	gtZero := "must more than 0"
-
 	// totalIncome
 	if t.TotalIncome < 0 {
 		errs = append(errs, ValidateErr{
@@ -101,21 +69,58 @@ func (t TaxCSV) validate() []ValidateErr {
 	if t.Wht > t.TotalIncome {
 		errs = append(errs, ValidateErr{
 			Field:   "wht",
-			Message: "must less than totalIncome",
+			Message: i18n.T(lang, i18n.LtTotalIncome),
 		})
 	}
 
-	// donation
-	if t.Donation < 0 {
-		errs = append(errs, ValidateErr{
-			Field:   "donation",
-			Message: gtZero,
-		})
+	// foreign incomes
+	for _, v := range t.ForeignIncomes {
+		if v.Amount < 0 {
+			errs = append(errs, ValidateErr{
+				Field:   "foreign income amount",
+				Message: gtZero,
+			})
+		}
+		if v.ForeignTax < 0 {
+			errs = append(errs, ValidateErr{
+				Field:   "foreign tax",
+				Message: gtZero,
+			})
+		}
+		if v.ForeignTax > v.Amount {
+			errs = append(errs, ValidateErr{
+				Field:   "foreign tax",
+				Message: i18n.T(lang, i18n.LtForeignAmount),
+			})
+		}
+	}
+
+	// allowances
+	for _, v := range t.Allowances {
+		if v.Amount < 0 {
+			errs = append(errs, ValidateErr{
+				Field:   v.AllowanceType + " amount",
+				Message: gtZero,
+			})
+		}
 	}
 
 	return errs
 }
 
+func (t TaxCalcualtions) validateCurrency(rates map[string]float64, lang string) []ValidateErr {
+	var errs []ValidateErr
+	for _, v := range t.ForeignIncomes {
+		if _, ok := rates[strings.ToUpper(v.Currency)]; !ok {
+			errs = append(errs, ValidateErr{
+				Field:   "currency",
+				Message: i18n.T(lang, i18n.CurrencyNotSupport, v.Currency),
+			})
+		}
+	}
+	return errs
+}
+
 func mapDeduct(deducts []TBDeduct) map[string]float64 {
 	m := make(map[string]float64)
 	for _, val := range deducts {
@@ -124,39 +129,35 @@ func mapDeduct(deducts []TBDeduct) map[string]float64 {
 	return m
 }
 
+func mapExchangeRate(rates []TBExchangeRate) map[string]float64 {
+	m := make(map[string]float64)
+	for _, val := range rates {
+		m[strings.ToUpper(val.Currency)] = val.Rate
+	}
+	return m
+}
+
 func personalDeduct(m map[string]float64) float64 {
 	return m["personal"]
 }
-func kReceiptDeduct(m map[string]float64) float64 {
-	return m["k-receipt"]
-}
-func donationDeduct(m map[string]float64) float64 {
-	return m["donation"]
-}
 
-func calcDeduct(allowances Allowances, m map[string]float64) float64 {
-	result := 0.0
-	kReceiptDeduction := kReceiptDeduct(m)
-	donateDeduction := donationDeduct(m)
-
-	switch allowances.AllowanceType {
-	case "donation":
-		if allowances.Amount > donateDeduction {
-			result += donateDeduction
-		} else {
-			result += allowances.Amount
-		}
-	case "k-receipt":
-		if allowances.Amount > kReceiptDeduction {
-			result += kReceiptDeduction
-		} else {
-			result += allowances.Amount
+// allowanceTypes returns the allowance types a request may claim, which
+// are every configured deduction except the personal one.
+func allowanceTypes(m map[string]float64) map[string]bool {
+	types := make(map[string]bool)
+	for k := range m {
+		if k != "personal" {
+			types[k] = true
 		}
-	default:
-		result = 0.0
 	}
+	return types
+}
 
-	return result
+func calcDeduct(allowances Allowances, m map[string]float64) float64 {
+	if !allowanceTypes(m)[allowances.AllowanceType] {
+		return 0.0
+	}
+	return math.Min(allowances.Amount, m[allowances.AllowanceType])
 }
 
 func calcTaxByLevel(tbTax TBTaxLevel, income float64) float64 {
@@ -170,150 +171,173 @@ func calcTaxByLevel(tbTax TBTaxLevel, income float64) float64 {
 	return result
 }
 
-func (h *Handler) TaxCalculationsHandler(c echo.Context) error {
-	var tax float64
-	var t TaxCalcualtions
-	err := c.Bind(&t)
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: invalidRequestErr})
+// TaxOnIncome returns the tax of a net income under levels.
+func TaxOnIncome(levels []TBTaxLevel, netIncome float64) float64 {
+	tax := 0.0
+	for _, l := range levels {
+		tax += calcTaxByLevel(l, netIncome)
 	}
+	return tax
+}
 
-	if err := t.validate(); len(err) > 0 {
-		return c.JSON(http.StatusBadRequest, err)
-	}
+// ruleset holds the deduction caps, tax levels and exchange rates a
+// calculation is evaluated against.
+type ruleset struct {
+	deducts map[string]float64
+	levels  []TBTaxLevel
+	rates   map[string]float64
+}
 
-	deducts, err := h.store.GetDeduct()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+// calculate computes the tax of a single taxpayer. Foreign incomes are
+// converted to baht and added to the assessable income; the foreign tax
+// already paid is credited up to the Thai tax attributable to that income.
+func calculate(t TaxCalcualtions, r ruleset, lang string) Tax {
+	var tax float64
+
+	foreignIncome := 0.0
+	foreignTax := 0.0
+	for _, f := range t.ForeignIncomes {
+		rate := r.rates[strings.ToUpper(f.Currency)]
+		foreignIncome += f.Amount * rate
+		foreignTax += f.ForeignTax * rate
 	}
-	m := mapDeduct(deducts)
 
-	personalDeduction := personalDeduct(m)
-	totalIncome := t.TotalIncome
-	wht := t.Wht
-	allowances := t.Allowances
+	personalDeduction := personalDeduct(r.deducts)
+	totalIncome := t.TotalIncome + foreignIncome
 	deduct := 0.0
 
-	for _, a := range allowances {
-		deduct += calcDeduct(a, m)
+	for _, a := range t.Allowances {
+		deduct += calcDeduct(a, r.deducts)
 	}
 	netIncome := (totalIncome - personalDeduction) - deduct
 
-	allLevels, err := h.store.GetTaxLevels()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
-	}
-
 	var taxLevel []TaxLevel
-	for _, t := range allLevels {
-		eachtax := calcTaxByLevel(t, netIncome)
+	for _, l := range r.levels {
+		eachtax := calcTaxByLevel(l, netIncome)
 		tax += eachtax
 		taxLevel = append(taxLevel, TaxLevel{
-			Level: t.Label,
+			Level: l.label(lang),
 			Tax:   eachtax,
 		})
 	}
 
-	tax = tax - wht
+	credit := calcForeignTaxCredit(tax, foreignIncome, foreignTax, totalIncome)
+	tax = tax - credit - t.Wht
 
-	var res Tax
 	if tax < 0 {
-		res = Tax{
-			Tax:       0.0,
-			TaxRefund: math.Abs(tax),
-			TaxLevel:  taxLevel,
-		}
-	} else {
-		res = Tax{
-			Tax:      tax,
-			TaxLevel: taxLevel,
+		return Tax{
+			Tax:              0.0,
+			TaxRefund:        math.Abs(tax),
+			ForeignTaxCredit: credit,
+			TaxLevel:         taxLevel,
 		}
 	}
-
-	return c.JSON(http.StatusOK, res)
+	return Tax{
+		Tax:              tax,
+		ForeignTaxCredit: credit,
+		TaxLevel:         taxLevel,
+	}
 }
 
-func (h *Handler) TaxCalculationsCSVHandler(c echo.Context) error {
-	file, err := c.FormFile("file")
-	if err != nil {
-		return c.JSON(http.StatusBadRequest, Err{Message: invalidRequestErr})
+func calcForeignTaxCredit(tax, foreignIncome, foreignTax, totalIncome float64) float64 {
+	if foreignIncome <= 0 || totalIncome <= 0 {
+		return 0.0
 	}
+	attributable := tax * foreignIncome / totalIncome
+	return math.Round(math.Min(foreignTax, attributable)*100) / 100
+}
 
-	f, err := file.Open()
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+// validTaxYear reports whether y is a tax year, or zero for none.
+func validTaxYear(y int) bool {
+	return y == 0 || (y >= 1900 && y <= 9999)
+}
+
+// effectiveDate is the day whose deductions apply to a calculation: the
+// last day of taxYear, or today when no tax year is given.
+func effectiveDate(taxYear int) time.Time {
+	if taxYear <= 0 {
+		return time.Now()
 	}
-	defer f.Close()
+	return time.Date(taxYear, time.December, 31, 0, 0, 0, 0, time.Local)
+}
 
-	data, err := io.ReadAll(f)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+// loadRuleset reads the rules in force at the given day from the cache
+// when there is one, or else from the store. Exchange rates are only read
+// when the request carries foreign incomes.
+func (h *Handler) loadRuleset(ctx context.Context, at time.Time, withRates bool) (ruleset, error) {
+	if h.rules != nil {
+		rules, err := h.rules.current(ctx)
+		if err != nil {
+			return ruleset{}, err
+		}
+		rates := map[string]float64{}
+		if withRates {
+			rates = mapExchangeRate(rules.Rates)
+		}
+		return ruleset{deducts: mapDeduct(deductsAt(rules.Deducts, at)), levels: rules.Levels, rates: rates}, nil
 	}
 
-	deducts, err := h.store.GetDeduct()
+	deducts, err := h.store.GetDeduct(ctx, at)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+		return ruleset{}, err
 	}
-	m := mapDeduct(deducts)
 
-	personalDeduction := personalDeduct(m)
-	// kReceiptDeduction := kReceiptDeduct(m)
-	donateDeduction := donationDeduct(m)
-
-	var taxCsv []TaxCSV
-	err = gocsv.UnmarshalBytes(data, &taxCsv)
+	allLevels, err := h.store.GetTaxLevels(ctx)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
+		return ruleset{}, err
 	}
 
-	var taxes []TaxesDetail
-	for i, t := range taxCsv {
-		if err := t.validate(); len(err) > 0 {
-			return c.JSON(http.StatusBadRequest, ValidateCSVErr{Message: fmt.Sprintf("%s on line %d", invalidDataFileErr, i+1), Data: err})
+	rates := map[string]float64{}
+	if withRates {
+		exchangeRates, err := h.store.GetExchangeRates(ctx)
+		if err != nil {
+			return ruleset{}, err
 		}
+		rates = mapExchangeRate(exchangeRates)
+	}
 
-		var tax float64
-		deduct := 0.0
+	return ruleset{deducts: mapDeduct(deducts), levels: allLevels, rates: rates}, nil
+}
 
-		totalIncome := t.TotalIncome
-		wht := t.Wht
-		donation := t.Donation
+func (h *Handler) TaxCalculationsHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	var t TaxCalcualtions
+	err := c.Bind(&t)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
 
-		if donation > donateDeduction {
-			deduct = deduct + donateDeduction
-		} else {
-			deduct = deduct + donation
-		}
+	t, errs, err := h.applyProfile(c.Request().Context(), t, lang)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, errs)
+	}
 
-		netIncome := (totalIncome - personalDeduction) - deduct
-		allLevels, err := h.store.GetTaxLevels()
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, Err{Message: err.Error()})
-		}
+	if err := t.validate(lang); len(err) > 0 {
+		return c.JSON(http.StatusBadRequest, err)
+	}
 
-		for _, t := range allLevels {
-			eachtax := calcTaxByLevel(t, netIncome)
-			tax += eachtax
-		}
+	r, err := h.loadRuleset(c.Request().Context(), effectiveDate(t.TaxYear), len(t.ForeignIncomes) > 0)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
 
-		tax = tax - wht
+	if err := t.validateCurrency(r.rates, lang); len(err) > 0 {
+		return c.JSON(http.StatusBadRequest, err)
+	}
 
-		if tax < 0 {
-			taxes = append(taxes, TaxesDetail{
-				TotalIncome: t.TotalIncome,
-				Tax:         0.0,
-				TaxRefund:   math.Abs(tax),
-			})
-		} else {
-			taxes = append(taxes, TaxesDetail{
-				TotalIncome: t.TotalIncome,
-				Tax:         tax,
-			})
-		}
+	res := calculate(t, r, lang)
+	if formatRequested(c) {
+		res = res.withFormat()
 	}
 
-	res := Taxes{
-		Taxes: taxes,
+	if err := h.record(c, history.KindTax, t, res, r, t.TaxID); err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
 	}
 
 	return c.JSON(http.StatusOK, res)
@@ -1,31 +1,82 @@
 package tax
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"math"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/connapotae/assessment-tax/history"
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/connapotae/assessment-tax/money"
+	"github.com/connapotae/assessment-tax/xlsx"
 	"github.com/labstack/echo/v4"
 )
 
 type StubTax struct {
-	taxLevel []TBTaxLevel
-	deduct   []TBDeduct
-	err      error
+	taxLevel      []TBTaxLevel
+	deduct        []TBDeduct
+	scheduled     []TBDeduct
+	scheduledFrom time.Time
+	rates         []TBExchangeRate
+	profiles      map[string]Profile
+	err           error
 }
 
-func (s StubTax) GetTaxLevels() ([]TBTaxLevel, error) {
+func (s StubTax) GetTaxLevels(ctx context.Context) ([]TBTaxLevel, error) {
 	return s.taxLevel, s.err
 }
 
-func (s StubTax) GetDeduct() ([]TBDeduct, error) {
-	return s.deduct, s.err
+func (s StubTax) GetDeduct(ctx context.Context, at time.Time) ([]TBDeduct, error) {
+	if len(s.scheduled) == 0 || at.Before(s.scheduledFrom) {
+		return s.deduct, s.err
+	}
+	deduct := append([]TBDeduct{}, s.deduct...)
+	for _, d := range s.scheduled {
+		for i := range deduct {
+			if deduct[i].DeductType == d.DeductType {
+				deduct[i] = d
+			}
+		}
+	}
+	return deduct, s.err
+}
+
+func (s StubTax) GetExchangeRates(ctx context.Context) ([]TBExchangeRate, error) {
+	return s.rates, s.err
+}
+
+func (s StubTax) GetProfile(ctx context.Context, id string) (Profile, error) {
+	p, ok := s.profiles[id]
+	if !ok {
+		return p, ErrProfileNotFound
+	}
+	return p, s.err
+}
+
+type StubRecorder struct {
+	records []history.Record
+	err     error
+}
+
+func (s *StubRecorder) SaveCalculation(ctx context.Context, r history.Record) error {
+	s.records = append(s.records, r)
+	return s.err
+}
+
+func (s *StubRecorder) SaveCalculations(ctx context.Context, records []history.Record) error {
+	s.records = append(s.records, records...)
+	return s.err
 }
 
 func TestTax(t *testing.T) {
@@ -62,6 +113,7 @@ func TestTax(t *testing.T) {
 			{
 				Level:      5,
 				Label:      "2,000,001 ขึ้นไป",
+				LabelEn:    "2,000,001 and above",
 				MinAmount:  2000000,
 				MaxAmount:  999999999999,
 				TaxPercent: 35,
@@ -81,6 +133,19 @@ func TestTax(t *testing.T) {
 				DeductAmount: 50000,
 			},
 		},
+		rates: []TBExchangeRate{
+			{
+				Currency: "USD",
+				Rate:     36.5,
+			},
+		},
+		profiles: map[string]Profile{
+			"p1": {
+				ID:         "p1",
+				TaxID:      "1101700230708",
+				Allowances: []Allowances{{AllowanceType: "donation", Amount: 100000.0}, {AllowanceType: "k-receipt", Amount: 50000.0}},
+			},
+		},
 	}
 
 	tests := []struct {
@@ -90,7 +155,12 @@ func TestTax(t *testing.T) {
 		want any
 	}{
 		{name: "given unable to get tax calculations should return 500 and error message", req: `{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [ { "allowanceType": "donation", "amount": 0.0 }]}`, stub: StubTax{err: echo.ErrInternalServerError}, want: http.StatusInternalServerError},
+		{name: "given the database query times out should return 504 and error message", req: `{ "totalIncome": 500000.0, "wht": 0.0, "allowances": []}`, stub: StubTax{err: context.DeadlineExceeded}, want: http.StatusGatewayTimeout},
+		{name: "given the request is cancelled while querying the database should return 503 and error message", req: `{ "totalIncome": 500000.0, "wht": 0.0, "allowances": []}`, stub: StubTax{err: context.Canceled}, want: http.StatusServiceUnavailable},
 		{name: "given unable to get tax calculations should return 400 and error message", req: "test tax calculations", stub: StubTax{}, want: http.StatusBadRequest},
+		{name: "given unable to get tax calculations with invalid tax id should return 400 and error message", req: `{ "taxId": "1101700230705", "totalIncome": 500000.0, "wht": 0.0, "allowances": []}`, stub: stubRefactoring, want: http.StatusBadRequest},
+		{name: "given unable to get tax calculations with unsupported currency should return 400 and error message", req: `{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [], "foreignIncomes": [{ "currency": "JPY", "amount": 10000.0, "foreignTax": 0.0 }]}`, stub: stubRefactoring, want: http.StatusBadRequest},
+		{name: "given unable to get tax calculations with foreign tax more than foreign income should return 400 and error message", req: `{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [], "foreignIncomes": [{ "currency": "USD", "amount": 100.0, "foreignTax": 200.0 }]}`, stub: stubRefactoring, want: http.StatusBadRequest},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -158,12 +228,19 @@ func TestTax(t *testing.T) {
 			stub: stubRefactoring,
 			want: Tax{Tax: 18700.0, TaxLevel: []TaxLevel{{Level: "0-150,000", Tax: 0}, {Level: "150,001-500,000", Tax: 18700.0}, {Level: "500,001-1,000,000", Tax: 0.0}, {Level: "1,000,001-2,000,000", Tax: 0.0}, {Level: "2,000,001 ขึ้นไป", Tax: 0.0}}},
 		},
+		{
+			name: "given user able to getting tax calculations with foreign income should return tax and foreign tax credit",
+			req:  `{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [], "foreignIncomes": [{ "currency": "usd", "amount": 10000.0, "foreignTax": 100.0 }]}`,
+			stub: stubRefactoring,
+			want: Tax{Tax: 77100.0, ForeignTaxCredit: 3650.0, TaxLevel: []TaxLevel{{Level: "0-150,000", Tax: 0}, {Level: "150,001-500,000", Tax: 35000.0}, {Level: "500,001-1,000,000", Tax: 45750.0}, {Level: "1,000,001-2,000,000", Tax: 0.0}, {Level: "2,000,001 ขึ้นไป", Tax: 0.0}}},
+		},
 	}
 	for _, tt := range tests2 {
 		t.Run(tt.name, func(t *testing.T) {
 			e := echo.New()
 			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.req))
 			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("Accept-Language", "th")
 
 			rec := httptest.NewRecorder()
 			c := e.NewContext(req, rec)
@@ -183,7 +260,7 @@ func TestTax(t *testing.T) {
 		})
 	}
 
-	t.Run("given unable to get tax calculations from csv contain wrong data should return 500 and error message", func(t *testing.T) {
+	t.Run("given unable to get tax calculations from csv contain wrong data should return 400 and error message", func(t *testing.T) {
 		body := new(bytes.Buffer)
 		writer := multipart.NewWriter(body)
 		part, err := writer.CreateFormFile("file", "file.csv")
@@ -207,8 +284,8 @@ func TestTax(t *testing.T) {
 		p := New(stubRefactoring)
 		p.TaxCalculationsCSVHandler(c)
 
-		if rec.Code != http.StatusInternalServerError {
-			t.Errorf("expected status code %d but got %d", http.StatusInternalServerError, rec.Code)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d", http.StatusBadRequest, rec.Code)
 		}
 	})
 
@@ -265,13 +342,1084 @@ func TestTax(t *testing.T) {
 
 		want := Taxes{
 			Taxes: []TaxesDetail{
-				{TotalIncome: 500000.0, Tax: 29000.0},
-				{TotalIncome: 600000.0, Tax: 0.0, TaxRefund: 2000.0},
-				{TotalIncome: 750000.0, Tax: 0.0, TaxRefund: 1500.0},
+				{Line: 2, TotalIncome: 500000.0, Tax: 29000.0},
+				{Line: 3, TotalIncome: 600000.0, Tax: 0.0, TaxRefund: 2000.0},
+				{Line: 4, TotalIncome: 750000.0, Tax: 0.0, TaxRefund: 1500.0},
+			},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v but got %v", want, got)
+		}
+	})
+
+	tests3 := []struct {
+		name string
+		req  string
+		stub StubTax
+		want any
+	}{
+		{
+			name: "given household with non-earning spouse should recommend joint filing",
+			req:  `{ "taxpayer": { "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }, "spouse": { "totalIncome": 0.0, "wht": 0.0, "allowances": [] }}`,
+			stub: stubRefactoring,
+			want: HouseholdTax{
+				Separate: SeparateFiling{
+					Taxpayer: Tax{Tax: 29000.0, TaxLevel: []TaxLevel{{Level: "0-150,000", Tax: 0}, {Level: "150,001-500,000", Tax: 29000.0}, {Level: "500,001-1,000,000", Tax: 0.0}, {Level: "1,000,001-2,000,000", Tax: 0.0}, {Level: "2,000,001 ขึ้นไป", Tax: 0.0}}},
+					Spouse:   Tax{Tax: 0.0, TaxLevel: []TaxLevel{{Level: "0-150,000", Tax: 0}, {Level: "150,001-500,000", Tax: 0.0}, {Level: "500,001-1,000,000", Tax: 0.0}, {Level: "1,000,001-2,000,000", Tax: 0.0}, {Level: "2,000,001 ขึ้นไป", Tax: 0.0}}},
+					Tax:      29000.0,
+				},
+				Joint:          Tax{Tax: 23000.0, TaxLevel: []TaxLevel{{Level: "0-150,000", Tax: 0}, {Level: "150,001-500,000", Tax: 23000.0}, {Level: "500,001-1,000,000", Tax: 0.0}, {Level: "1,000,001-2,000,000", Tax: 0.0}, {Level: "2,000,001 ขึ้นไป", Tax: 0.0}}},
+				Recommendation: "joint",
+			},
+		},
+		{
+			name: "given household with two earners should recommend separate filing",
+			req:  `{ "taxpayer": { "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }, "spouse": { "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }}`,
+			stub: stubRefactoring,
+			want: HouseholdTax{
+				Separate: SeparateFiling{
+					Taxpayer: Tax{Tax: 29000.0, TaxLevel: []TaxLevel{{Level: "0-150,000", Tax: 0}, {Level: "150,001-500,000", Tax: 29000.0}, {Level: "500,001-1,000,000", Tax: 0.0}, {Level: "1,000,001-2,000,000", Tax: 0.0}, {Level: "2,000,001 ขึ้นไป", Tax: 0.0}}},
+					Spouse:   Tax{Tax: 29000.0, TaxLevel: []TaxLevel{{Level: "0-150,000", Tax: 0}, {Level: "150,001-500,000", Tax: 29000.0}, {Level: "500,001-1,000,000", Tax: 0.0}, {Level: "1,000,001-2,000,000", Tax: 0.0}, {Level: "2,000,001 ขึ้นไป", Tax: 0.0}}},
+					Tax:      58000.0,
+				},
+				Joint:          Tax{Tax: 92000.0, TaxLevel: []TaxLevel{{Level: "0-150,000", Tax: 0}, {Level: "150,001-500,000", Tax: 35000.0}, {Level: "500,001-1,000,000", Tax: 57000.0}, {Level: "1,000,001-2,000,000", Tax: 0.0}, {Level: "2,000,001 ขึ้นไป", Tax: 0.0}}},
+				Recommendation: "separate",
 			},
+		},
+	}
+	for _, tt := range tests3 {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.req))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("Accept-Language", "th")
+
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/tax/calculations/household")
+
+			p := New(tt.stub)
+			p.HouseholdCalculationsHandler(c)
+
+			gotJson := rec.Body.Bytes()
+			var got HouseholdTax
+			if err := json.Unmarshal(gotJson, &got); err != nil {
+				t.Errorf("unable to unmarshal json: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v but got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("given unable to get household calculations with invalid spouse should return 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "taxpayer": { "totalIncome": 500000.0 }, "spouse": { "totalIncome": -1.0 }}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/household")
+
+		p := New(stubRefactoring)
+		p.HouseholdCalculationsHandler(c)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+
+	t.Run("given user able to getting tax calculations from withholding certificates should return tax", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "allowances": [], "certificates": [
+			{ "payerTaxId": "0105555001010", "incomeType": "40(1)", "amountPaid": 300000.0, "taxWithheld": 10000.0 },
+			{ "payerTaxId": "3105500123452", "incomeType": "40(2)", "amountPaid": 200000.0, "taxWithheld": 5000.0 }]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Accept-Language", "th")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/withholding")
+
+		p := New(stubRefactoring)
+		p.WithholdingCalculationsHandler(c)
+
+		var got WithholdingTax
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		want := WithholdingTax{
+			Tax:         Tax{Tax: 14000.0, TaxLevel: []TaxLevel{{Level: "0-150,000", Tax: 0}, {Level: "150,001-500,000", Tax: 29000.0}, {Level: "500,001-1,000,000", Tax: 0.0}, {Level: "1,000,001-2,000,000", Tax: 0.0}, {Level: "2,000,001 ขึ้นไป", Tax: 0.0}}},
+			TotalIncome: 500000.0,
+			Wht:         15000.0,
+			Incomes:     []IncomeByType{{IncomeType: "40(1)", Amount: 300000.0}, {IncomeType: "40(2)", Amount: 200000.0}},
 		}
 		if !reflect.DeepEqual(got, want) {
 			t.Errorf("expected %v but got %v", want, got)
 		}
 	})
+
+	t.Run("given user able to getting tax calculations from withholding certificates csv should return tax", func(t *testing.T) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		writer.WriteField("calculation", `{ "allowances": [ { "allowanceType": "donation", "amount": 200000.0 } ] }`)
+		part, err := writer.CreateFormFile("file", "certificates.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(part, strings.NewReader("payerTaxId,incomeType,amountPaid,taxWithheld\n0105555001010,40(1),500000,20000\n"))
+		writer.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/withholding")
+
+		p := New(stubRefactoring)
+		p.WithholdingCalculationsHandler(c)
+
+		var got WithholdingTax
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		if got.Tax.Tax != 0.0 || got.Tax.TaxRefund != 1000.0 || got.Wht != 20000.0 {
+			t.Errorf("expected tax refund 1000 with wht 20000 but got %v", got)
+		}
+	})
+
+	t.Run("given unable to get tax calculations from withholding certificate with invalid payer tax id should return 400 and error message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "certificates": [
+			{ "payerTaxId": "0105555001011", "incomeType": "40(1)", "amountPaid": 300000.0, "taxWithheld": 10000.0 }]}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/withholding")
+
+		p := New(stubRefactoring)
+		p.WithholdingCalculationsHandler(c)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d", http.StatusBadRequest, rec.Code)
+		}
+	})
+
+	csvTests := []struct {
+		name  string
+		query string
+		csv   string
+		code  int
+		want  any
+	}{
+		{
+			name:  "given user able to getting tax calculations from csv with tax id should return masked tax id",
+			query: "?maskTaxId=true",
+			csv:   "taxId,totalIncome,wht,donation\n1101700230708,500000,0,0\n3105500123452,600000,40000,20000\n",
+			code:  http.StatusOK,
+			want:  Taxes{Taxes: []TaxesDetail{{Line: 2, TaxID: "1xxxxxxxx0708", TotalIncome: 500000.0, Tax: 29000.0}, {Line: 3, TaxID: "3xxxxxxxx3452", TotalIncome: 600000.0, Tax: 0.0, TaxRefund: 2000.0}}},
+		},
+		{
+			name:  "given user able to getting tax calculations from csv with allowance columns should return same tax as json and ignored columns",
+			query: "",
+			csv:   "totalIncome,wht,k-receipt,donation,department\n500000,0,200000,100000,HR\n500000,0,3000,100000,IT\n",
+			code:  http.StatusOK,
+			want:  Taxes{Taxes: []TaxesDetail{{Line: 2, Columns: map[string]string{"department": "HR"}, TotalIncome: 500000.0, Tax: 14000.0}, {Line: 3, Columns: map[string]string{"department": "IT"}, TotalIncome: 500000.0, Tax: 18700.0}}, IgnoredColumns: []string{"department"}},
+		},
+		{
+			name:  "given user able to getting tax calculations from csv with bom, semicolons and header spacing should return tax",
+			query: "",
+			csv:   "\ufeff TotalIncome ; WHT ;Donation\r\n500000;0;0\r\n",
+			code:  http.StatusOK,
+			want:  Taxes{Taxes: []TaxesDetail{{Line: 2, TotalIncome: 500000.0, Tax: 29000.0}}},
+		},
+		{
+			name:  "given user able to getting tax calculations from csv with formatted amounts should return tax",
+			query: "",
+			csv:   "totalIncome,wht,donation\n\"1,200,000.00\",\" 40,000 \",\"฿๑๐๐,๐๐๐\"\n",
+			code:  http.StatusOK,
+			want:  Taxes{Taxes: []TaxesDetail{{Line: 2, TotalIncome: 1200000.0, Tax: 78000.0}}},
+		},
+		{
+			name:  "given user able to getting tax calculations from csv with decimal comma should return tax",
+			query: "?delimiter=%3B",
+			csv:   "totalIncome;wht;donation\n1.200.000,00;40000;100000\n",
+			code:  http.StatusOK,
+			want:  Taxes{Taxes: []TaxesDetail{{Line: 2, TotalIncome: 1200000.0, Tax: 78000.0}}},
+		},
+		{
+			name:  "given unable to get tax calculations from comma delimited csv with a lone comma that is not a thousands separator should return 400 and error message",
+			query: "",
+			csv:   "totalIncome,wht,donation\n\"12,5000\",0,0\n",
+			code:  http.StatusBadRequest,
+		},
+		{
+			name:  "given user able to getting tax calculations from csv in windows-874 should return tax and thai ignored column",
+			query: "",
+			csv:   "\xaa\xd7\xe8\xcd,totalIncome,wht,donation\n\xca\xc1\xaa\xd2\xc2,500000,0,0\n",
+			code:  http.StatusOK,
+			want:  Taxes{Taxes: []TaxesDetail{{Line: 2, Columns: map[string]string{"ชื่อ": "สมชาย"}, TotalIncome: 500000.0, Tax: 29000.0}}, IgnoredColumns: []string{"ชื่อ"}},
+		},
+		{
+			name:  "given user able to getting tax calculations from csv with declared encoding and delimiter should return tax",
+			query: "?encoding=tis-620&delimiter=%09",
+			csv:   "totalIncome\twht\tdonation\n500000\t0\t0\n",
+			code:  http.StatusOK,
+			want:  Taxes{Taxes: []TaxesDetail{{Line: 2, TotalIncome: 500000.0, Tax: 29000.0}}},
+		},
+		{
+			name:  "given unable to get tax calculations from csv with unknown encoding should return 400 and error message",
+			query: "?encoding=ebcdic",
+			csv:   "totalIncome,wht,donation\n500000,0,0\n",
+			code:  http.StatusBadRequest,
+		},
+		{
+			name:  "given user able to getting tax calculations from csv with identifier columns should return them unchanged with the line",
+			query: "",
+			csv:   "employeeId,name,totalIncome,wht,donation,department\nE-001,สมชาย ใจดี,500000,0,0,HR\nE-002, Jane ,500000,0,0,\n",
+			code:  http.StatusOK,
+			want: Taxes{
+				Taxes: []TaxesDetail{
+					{Line: 2, Columns: map[string]string{"employeeId": "E-001", "name": "สมชาย ใจดี", "department": "HR"}, TotalIncome: 500000.0, Tax: 29000.0},
+					{Line: 3, Columns: map[string]string{"employeeId": "E-002", "name": " Jane ", "department": ""}, TotalIncome: 500000.0, Tax: 29000.0},
+				},
+				IgnoredColumns: []string{"employeeId", "name", "department"},
+			},
+		},
+		{
+			name:  "given unable to get tax calculations from csv without totalIncome column should return 400 and error message",
+			query: "",
+			csv:   "wht,donation\n0,0\n",
+			code:  http.StatusBadRequest,
+		},
+		{
+			name:  "given unable to get tax calculations from csv with duplicate tax id should return 400 and error message",
+			query: "",
+			csv:   "taxId,totalIncome,wht,donation\n1101700230708,500000,0,0\n1101700230708,600000,40000,20000\n",
+			code:  http.StatusBadRequest,
+		},
+		{
+			name:  "given unable to get tax calculations from csv with invalid tax id should return 400 and error message",
+			query: "",
+			csv:   "taxId,totalIncome,wht,donation\n1101700230705,500000,0,0\n",
+			code:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range csvTests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", "file.csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(part, strings.NewReader(tt.csv))
+			writer.Close()
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/"+tt.query, body)
+			req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/tax/calculations/upload-csv")
+
+			p := New(stubRefactoring)
+			p.TaxCalculationsCSVHandler(c)
+
+			if rec.Code != tt.code {
+				t.Errorf("expected status code %d but got %d", tt.code, rec.Code)
+			}
+			if tt.want == nil {
+				return
+			}
+			var got Taxes
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Errorf("unable to unmarshal json: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v but got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("given user able to getting tax calculations in english should return english label and message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Accept-Language", "en-US,en;q=0.9,th;q=0.8")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsHandler(c)
+
+		var got Tax
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		if got.TaxLevel[4].Level != "2,000,001 and above" {
+			t.Errorf("expected english label but got %v", got.TaxLevel[4].Level)
+		}
+	})
+
+	t.Run("given unable to get tax calculations without accept language should return english validation message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "totalIncome": -1.0, "wht": 0.0, "allowances": [] }`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsHandler(c)
+
+		var got []ValidateErr
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		if len(got) == 0 || got[0].Message != i18n.T(i18n.EN, i18n.GtZero) {
+			t.Errorf("expected english message but got %v", got)
+		}
+	})
+
+	t.Run("given unable to get tax calculations in thai should return thai validation message", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "totalIncome": -1.0, "wht": 0.0, "allowances": [] }`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Accept-Language", "th-TH")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsHandler(c)
+
+		var got []ValidateErr
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		if len(got) == 0 || got[0].Message != "ต้องมีค่ามากกว่า 0" {
+			t.Errorf("expected thai message but got %v", got)
+		}
+	})
+
+	t.Run("given user able to getting tax calculations with format should return formatted amount and baht text", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/?format=true", strings.NewReader(`{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsHandler(c)
+
+		var got Tax
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		want := money.Amount{Text: "29,000.00", Words: "สองหมื่นเก้าพันบาทถ้วน"}
+		if got.Formatted["tax"] != want {
+			t.Errorf("expected %v but got %v", want, got.Formatted["tax"])
+		}
+		if got.TaxLevel[1].Formatted["tax"] != want {
+			t.Errorf("expected %v but got %v", want, got.TaxLevel[1].Formatted["tax"])
+		}
+	})
+
+	t.Run("given household calculations with format should return formatted separate totals", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/?format=true", strings.NewReader(`{ "taxpayer": { "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }, "spouse": { "totalIncome": 500000.0, "wht": 80000.0, "allowances": [] }}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/household")
+
+		p := New(stubRefactoring)
+		p.HouseholdCalculationsHandler(c)
+
+		var got HouseholdTax
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		want := map[string]money.Amount{
+			"tax":       money.New(0.0),
+			"taxRefund": money.New(22000.0),
+		}
+		if !reflect.DeepEqual(got.Separate.Formatted, want) {
+			t.Errorf("expected %v but got %v", want, got.Separate.Formatted)
+		}
+		if got.Separate.Taxpayer.Formatted["tax"] != money.New(29000.0) || got.Joint.Formatted == nil {
+			t.Errorf("expected formatted members and joint filing but got %v %v", got.Separate.Taxpayer.Formatted, got.Joint.Formatted)
+		}
+	})
+
+	t.Run("given a csv whose rows no longer parse when streamed should return 400 instead of a partial 200", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		opts := Options{Lang: "en"}
+		r := ruleset{deducts: map[string]float64{"personal": 60000.0, "donation": 100000.0}, levels: stubRefactoring.taxLevel}
+		rows, errs := newCSVRows(strings.NewReader("totalIncome,wht,donation\n500000,0,abc\n"), opts, allowanceTypes(r.deducts))
+		if len(errs) > 0 {
+			t.Fatalf("expected the header to parse but got %v", errs)
+		}
+
+		streamTaxes(c, rows, r, opts, nil)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status code %d but got %d %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("given unable to get tax calculations from csv with several invalid rows should return every error with line and column", func(t *testing.T) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "file.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(part, strings.NewReader("totalIncome,wht,donation\n-1,0,0\n500000,0,0\n750000,50000,test\n"))
+		writer.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		req.Header.Set("Accept-Language", "en")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/upload-csv")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsCSVHandler(c)
+
+		var got ValidateCSVErr
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		want := []ValidateErr{
+			{Line: 2, Column: 1, Field: "totalIncome", Message: "must more than 0"},
+			{Line: 2, Column: 2, Field: "wht", Message: "must less than totalIncome"},
+			{Line: 4, Column: 3, Field: "donation", Message: "must be a number"},
+		}
+		if rec.Code != http.StatusBadRequest || !reflect.DeepEqual(got.Data, want) {
+			t.Errorf("expected %v but got %d %v", want, rec.Code, got.Data)
+		}
+	})
+
+	t.Run("given user able to getting tax calculations from csv in partial mode should return valid rows and errors of invalid rows", func(t *testing.T) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "file.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(part, strings.NewReader("totalIncome,wht,donation\n500000,0,0\n750000,50000,test\n600000,40000,20000\n"))
+		writer.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/?mode=partial", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		req.Header.Set("Accept-Language", "en")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/upload-csv")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsCSVHandler(c)
+
+		var got PartialTaxes
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		want := PartialTaxes{
+			Results: []RowResult{
+				{Line: 2, Status: "ok", Result: &TaxesDetail{Line: 2, TotalIncome: 500000.0, Tax: 29000.0}},
+				{Line: 3, Status: "error", Errors: []ValidateErr{{Line: 3, Column: 3, Field: "donation", Message: "must be a number"}}},
+				{Line: 4, Status: "ok", Result: &TaxesDetail{Line: 4, TotalIncome: 600000.0, Tax: 0.0, TaxRefund: 2000.0}},
+			},
+			Summary: BatchSummary{Total: 3, Succeeded: 2, Failed: 1},
+		}
+		if rec.Code != http.StatusOK || !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v but got %d %v", want, rec.Code, got)
+		}
+	})
+
+	t.Run("given user able to getting tax calculations from csv as ndjson should return one tax per line and a summary line", func(t *testing.T) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "file.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(part, strings.NewReader("totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n"))
+		writer.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		req.Header.Set(echo.HeaderAccept, "application/x-ndjson")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/upload-csv")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsCSVHandler(c)
+
+		want := "{\"type\":\"row\",\"row\":{\"line\":2,\"totalIncome\":500000,\"tax\":29000}}\n" +
+			"{\"type\":\"row\",\"row\":{\"line\":3,\"totalIncome\":600000,\"tax\":0,\"taxRefund\":2000}}\n" +
+			"{\"type\":\"summary\"}\n"
+		if got := rec.Body.String(); got != want {
+			t.Errorf("expected %q but got %q", want, got)
+		}
+	})
+
+	t.Run("given csv with unknown column as ndjson should return ignored columns on the summary line", func(t *testing.T) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "file.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(part, strings.NewReader("totalIncome,wht,donation,note\n500000,0,0,x\n"))
+		writer.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		req.Header.Set(echo.HeaderAccept, "application/x-ndjson")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/upload-csv")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsCSVHandler(c)
+
+		want := "{\"type\":\"row\",\"row\":{\"line\":2,\"columns\":{\"note\":\"x\"},\"totalIncome\":500000,\"tax\":29000}}\n" +
+			"{\"type\":\"summary\",\"ignoredColumns\":[\"note\"]}\n"
+		if got := rec.Body.String(); got != want {
+			t.Errorf("expected %q but got %q", want, got)
+		}
+	})
+
+	t.Run("given user able to getting tax calculations from csv as csv should return source columns with tax and tax by level", func(t *testing.T) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "file.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(part, strings.NewReader("totalIncome,wht,donation\n500000,0,0\n600000,40000,20000\n"))
+		writer.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		req.Header.Set(echo.HeaderAccept, "text/csv")
+		req.Header.Set("Accept-Language", "th")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/upload-csv")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsCSVHandler(c)
+
+		want := "totalIncome,wht,donation,tax,taxRefund,\"0-150,000\",\"150,001-500,000\",\"500,001-1,000,000\",\"1,000,001-2,000,000\",\"2,000,001 ขึ้นไป\"\n" +
+			"500000,0,0,29000,0,0,29000,0,0,0\n" +
+			"600000,40000,20000,0,2000,0,35000,3000,0,0\n"
+		if got := rec.Body.String(); got != want {
+			t.Errorf("expected %q but got %q", want, got)
+		}
+	})
+
+	t.Run("given user able to getting tax calculations from csv as xlsx should return a workbook", func(t *testing.T) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "file.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(part, strings.NewReader("totalIncome,wht,donation\n500000,0,0\n"))
+		writer.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/?mode=partial", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		req.Header.Set(echo.HeaderAccept, MIMEXLSX)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/upload-csv")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsCSVHandler(c)
+
+		if got := rec.Header().Get(echo.HeaderContentType); got != MIMEXLSX {
+			t.Errorf("expected content type %s but got %s", MIMEXLSX, got)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			if f.Name != "xl/worksheets/sheet1.xml" {
+				continue
+			}
+			r, _ := f.Open()
+			sheet, _ := io.ReadAll(r)
+			if !strings.Contains(string(sheet), "<v>29000</v>") || !strings.Contains(string(sheet), ">ok<") {
+				t.Errorf("expected sheet with tax 29000 and status ok but got %s", sheet)
+			}
+			return
+		}
+		t.Errorf("expected a worksheet in the workbook")
+	})
+
+	t.Run("given user able to getting tax calculations from xlsx should return the same result as csv", func(t *testing.T) {
+		var sheet bytes.Buffer
+		w, _ := xlsx.NewWriter(&sheet, "Payroll")
+		w.Write([]any{"totalIncome", "wht", "donation"})
+		w.Write([]any{500000.0, 0.0, 0.0})
+		w.Write([]any{600000.0, 40000.0, 20000.0})
+		w.Close()
+
+		for _, sheetName := range []string{"", "payroll"} {
+			body := new(bytes.Buffer)
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("file", "payroll.csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write(sheet.Bytes())
+			writer.Close()
+
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/?sheet="+sheetName, body)
+			req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/tax/calculations/upload-csv")
+
+			p := New(stubRefactoring)
+			p.TaxCalculationsCSVHandler(c)
+
+			want := `{"taxes":[{"line":2,"totalIncome":500000,"tax":29000},{"line":3,"totalIncome":600000,"tax":0,"taxRefund":2000}]}`
+			if got := strings.TrimSpace(rec.Body.String()); rec.Code != http.StatusOK || got != want {
+				t.Errorf("sheet %q: expected %d %s but got %d %s", sheetName, http.StatusOK, want, rec.Code, got)
+			}
+		}
+	})
+
+	t.Run("given user able to getting tax calculations from xlsx with unknown sheet should return 400", func(t *testing.T) {
+		var sheet bytes.Buffer
+		w, _ := xlsx.NewWriter(&sheet, "Payroll")
+		w.Write([]any{"totalIncome"})
+		w.Close()
+
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "payroll.xlsx")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(sheet.Bytes())
+		writer.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/?sheet=Summary", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		req.Header.Set("Accept-Language", "en")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/upload-csv")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsCSVHandler(c)
+
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "sheet Summary not found") {
+			t.Errorf("expected %d with sheet not found but got %d %s", http.StatusBadRequest, rec.Code, rec.Body.String())
+		}
+	})
+	t.Run("given user able to dry run csv should return errors, warnings and row count without tax", func(t *testing.T) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "file.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(part, strings.NewReader("totalIncome,wht,donation\n500000,0,200000\n100000,50000,0\n750000,50000,test\n"))
+		writer.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/?dryRun=true", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		req.Header.Set("Accept-Language", "en")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/upload-csv")
+
+		p := New(stubRefactoring)
+		p.TaxCalculationsCSVHandler(c)
+
+		var got DryRun
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("unable to unmarshal json: %v", err)
+		}
+		want := DryRun{
+			Rows:   3,
+			Valid:  false,
+			Errors: []ValidateErr{{Line: 4, Column: 3, Field: "donation", Message: "must be a number"}},
+			Warnings: []ValidateErr{
+				{Line: 2, Column: 3, Field: "donation", Message: "above the deduction cap, 100,000.00 will be used"},
+				{Line: 3, Column: 2, Field: "wht", Message: "wht is more than 35% of totalIncome"},
+			},
+		}
+		if rec.Code != http.StatusOK || !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v but got %d %v", want, rec.Code, got)
+		}
+	})
+	t.Run("given history enabled should record input, result, rules version and client of calculations", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "taxId": "1101700230708", "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderXRealIP, "10.0.0.1")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations")
+
+		recorder := &StubRecorder{}
+		New(stubRefactoring).WithRecorder(recorder).TaxCalculationsHandler(c)
+
+		if rec.Code != http.StatusOK || len(recorder.records) != 1 {
+			t.Fatalf("expected one recorded calculation but got %d %v", rec.Code, recorder.records)
+		}
+		got := recorder.records[0]
+		if got.Kind != history.KindTax || !reflect.DeepEqual(got.TaxIDs, []string{"1101700230708"}) || got.Client != "10.0.0.1" || got.RulesetVersion == "" {
+			t.Errorf("unexpected record %+v", got)
+		}
+		if !strings.Contains(string(got.Input), `"totalIncome":500000`) || !strings.Contains(string(got.Result), `"tax":29000`) {
+			t.Errorf("expected input and result in record but got %s %s", got.Input, got.Result)
+		}
+	})
+
+	t.Run("given rules with an open-ended top level should version them by content", func(t *testing.T) {
+		levels := func(topRate int) []TBTaxLevel {
+			return []TBTaxLevel{
+				{Level: 1, Label: "0-150,000", MinAmount: 0, MaxAmount: 150000, TaxPercent: 0},
+				{Level: 2, Label: "150,001 ขึ้นไป", MinAmount: 150000, MaxAmount: math.Inf(1), TaxPercent: topRate},
+			}
+		}
+		deducts := map[string]float64{"personal": 60000}
+
+		v1, err := ruleset{deducts: deducts, levels: levels(10)}.version()
+		if err != nil {
+			t.Fatalf("expected no error but got %v", err)
+		}
+		v2, err := ruleset{deducts: deducts, levels: levels(15)}.version()
+		if err != nil {
+			t.Fatalf("expected no error but got %v", err)
+		}
+		v3, _ := ruleset{deducts: map[string]float64{"personal": 50000}, levels: levels(10)}.version()
+		again, _ := ruleset{deducts: deducts, levels: levels(10)}.version()
+
+		if v1 == v2 || v1 == v3 {
+			t.Errorf("expected different rules to have different versions but got %s %s %s", v1, v2, v3)
+		}
+		if v1 != again {
+			t.Errorf("expected the same rules to have the same version but got %s and %s", v1, again)
+		}
+	})
+
+	t.Run("given history enabled should record every calculated row of an uploaded csv", func(t *testing.T) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, err := writer.CreateFormFile("file", "file.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(part, strings.NewReader("taxId,totalIncome,wht,donation\n1101700230708,500000,0,0\n,600000,40000,20000\n"))
+		writer.Close()
+
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", body)
+		req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+		req.Header.Set(echo.HeaderXRealIP, "10.0.0.1")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations/upload-csv")
+
+		recorder := &StubRecorder{}
+		New(stubRefactoring).WithRecorder(recorder).TaxCalculationsCSVHandler(c)
+
+		if rec.Code != http.StatusOK || len(recorder.records) != 2 {
+			t.Fatalf("expected two recorded rows but got %d %v", rec.Code, recorder.records)
+		}
+		got := recorder.records[0]
+		if got.Kind != history.KindCSV || !reflect.DeepEqual(got.TaxIDs, []string{"1101700230708"}) || got.Client != "10.0.0.1" || got.RulesetVersion == "" {
+			t.Errorf("unexpected record %+v", got)
+		}
+		if !strings.Contains(string(recorder.records[1].Input), `"totalIncome":600000`) || len(recorder.records[1].TaxIDs) != 0 {
+			t.Errorf("expected second row in record but got %+v", recorder.records[1])
+		}
+	})
+
+	t.Run("given history enabled should record the rows a batch job calculates", func(t *testing.T) {
+		recorder := &StubRecorder{}
+		h := New(stubRefactoring).WithRecorder(recorder)
+
+		var results []RowResult
+		err := h.ProcessCSV(context.Background(), strings.NewReader("totalIncome,wht\n500000,0\n600000,0\n-1,0\n"), Options{Client: "10.0.0.2"}, 1, func(res RowResult) error {
+			results = append(results, res)
+			return nil
+		})
+
+		if err != nil || len(results) != 2 || len(recorder.records) != 1 {
+			t.Fatalf("expected one recorded row after the skipped one but got %v %v %v", err, results, recorder.records)
+		}
+		if got := recorder.records[0]; got.Kind != history.KindCSV || got.Client != "10.0.0.2" || !strings.Contains(string(got.Input), `"totalIncome":600000`) {
+			t.Errorf("unexpected record %+v", got)
+		}
+	})
+
+	t.Run("given history unable to record should return 500", func(t *testing.T) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/tax/calculations")
+
+		New(stubRefactoring).WithRecorder(&StubRecorder{err: errors.New("db down")}).TaxCalculationsHandler(c)
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d but got %d", http.StatusInternalServerError, rec.Code)
+		}
+	})
+	profileTests := []struct {
+		name string
+		req  string
+		code int
+		want Tax
+	}{
+		{
+			name: "given calculation with profile id should merge standing allowances of the profile",
+			req:  `{ "profileId": "p1", "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`,
+			code: http.StatusOK,
+			want: Tax{Tax: 14000.0},
+		},
+		{
+			name: "given calculation with profile id and allowance of the same type should use the allowance of the request",
+			req:  `{ "profileId": "p1", "totalIncome": 500000.0, "wht": 0.0, "allowances": [{ "allowanceType": "k-receipt", "amount": 0.0 }] }`,
+			code: http.StatusOK,
+			want: Tax{Tax: 19000.0},
+		},
+		{
+			name: "given calculation with unknown profile id should return 400",
+			req:  `{ "profileId": "p2", "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "given calculation with tax id other than the profile should return 400",
+			req:  `{ "profileId": "p1", "taxId": "3105500123452", "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`,
+			code: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range profileTests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.req))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/tax/calculations")
+
+			New(stubRefactoring).TaxCalculationsHandler(c)
+
+			if rec.Code != tt.code {
+				t.Fatalf("expected status code %d but got %d %s", tt.code, rec.Code, rec.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var got Tax
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Errorf("unable to unmarshal json: %v", err)
+			}
+			if got.Tax != tt.want.Tax {
+				t.Errorf("expected tax %v but got %v", tt.want.Tax, got.Tax)
+			}
+		})
+	}
+	stubScheduled := stubRefactoring
+	stubScheduled.scheduled = []TBDeduct{{DeductType: "personal", DeductAmount: 70000}}
+	stubScheduled.scheduledFrom = time.Date(2027, time.January, 1, 0, 0, 0, 0, time.Local)
+	effectiveTests := []struct {
+		name string
+		path string
+		req  string
+		code int
+		want float64
+	}{
+		{name: "given tax year before a scheduled deduction should use the deduction in force then", path: "/tax/calculations", req: `{ "taxYear": 2026, "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`, code: http.StatusOK, want: 29000.0},
+		{name: "given tax year of a scheduled deduction should use the scheduled deduction", path: "/tax/calculations", req: `{ "taxYear": 2027, "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`, code: http.StatusOK, want: 28000.0},
+		{name: "given invalid tax year should return 400", path: "/tax/calculations", req: `{ "taxYear": 27, "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`, code: http.StatusBadRequest},
+		{name: "given household with different tax years should return 400", path: "/tax/calculations/household", req: `{ "taxpayer": { "taxYear": 2026, "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }, "spouse": { "taxYear": 2027, "totalIncome": 0.0, "wht": 0.0, "allowances": [] } }`, code: http.StatusBadRequest},
+	}
+	for _, tt := range effectiveTests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.req))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath(tt.path)
+
+			h := New(stubScheduled)
+			if tt.path == "/tax/calculations/household" {
+				h.HouseholdCalculationsHandler(c)
+			} else {
+				h.TaxCalculationsHandler(c)
+			}
+
+			if rec.Code != tt.code {
+				t.Fatalf("expected status code %d but got %d %s", tt.code, rec.Code, rec.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+			var got Tax
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Errorf("unable to unmarshal json: %v", err)
+			}
+			if got.Tax != tt.want {
+				t.Errorf("expected tax %v but got %v", tt.want, got.Tax)
+			}
+		})
+	}
+}
+
+func TestParseNumber(t *testing.T) {
+	tests := map[string]float64{
+		"1200000":      1200000,
+		"1,200,000.00": 1200000,
+		"1 200 000":    1200000,
+		"฿1,200":       1200,
+		"1,200 บาท":    1200,
+		"(1,000.25)":   -1000.25,
+		"๑,๒๐๐,๐๐๐":    1200000,
+		"1'200'000.00": 1200000,
+		" 1,200.00":    1200,
+	}
+	for in, want := range tests {
+		got, err := parseNumber(in, false)
+		if err != nil || got != want {
+			t.Errorf("parseNumber(%q) expected %v but got %v %v", in, want, got, err)
+		}
+	}
+
+	for _, in := range []string{"abc", "1,2,3.4.5", "NaN", "Inf", "12,5000", "1,50", "1200,50", "1.200.000,50", ",500", "1,2000.00"} {
+		if _, err := parseNumber(in, false); err == nil {
+			t.Errorf("parseNumber(%q) expected an error", in)
+		}
+	}
+
+	decimal := map[string]float64{
+		"1.200.000,50": 1200000.5,
+		"1200,50":      1200.5,
+		"1,50":         1.5,
+		"1,200":        1200,
+		"1,200.50":     1200.5,
+	}
+	for in, want := range decimal {
+		got, err := parseNumber(in, true)
+		if err != nil || got != want {
+			t.Errorf("parseNumber(%q) with decimal comma expected %v but got %v %v", in, want, got, err)
+		}
+	}
+}
+
+type StubLoader struct {
+	rules Rules
+	loads int
+	err   error
+}
+
+func (s *StubLoader) LoadRules(ctx context.Context) (Rules, error) {
+	s.loads++
+	return s.rules, s.err
+}
+
+func TestRulesCache(t *testing.T) {
+	levels := []TBTaxLevel{
+		{Level: 1, Label: "0-150,000", MinAmount: 0, MaxAmount: 150000, TaxPercent: 0},
+		{Level: 2, Label: "150,001 ขึ้นไป", MinAmount: 150000, MaxAmount: math.Inf(1), TaxPercent: 10},
+	}
+	deducts := []TBDeduct{
+		{DeductType: "personal", DeductAmount: 60000, EffectiveFrom: time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{DeductType: "personal", DeductAmount: 70000, EffectiveFrom: time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{DeductType: "donation", DeductAmount: 100000, EffectiveFrom: time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	calculate := func(h *Handler, req string) (int, Tax) {
+		e := echo.New()
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(req))
+		r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(r, rec)
+		h.TaxCalculationsHandler(c)
+		var got Tax
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		return rec.Code, got
+	}
+
+	t.Run("given cached rules should read the rules from the store once", func(t *testing.T) {
+		loader := &StubLoader{rules: Rules{Levels: levels, Deducts: deducts}}
+		h := New(StubTax{err: echo.ErrInternalServerError}).WithRulesCache(NewRulesCache(loader, time.Hour))
+
+		for i := 0; i < 3; i++ {
+			if code, got := calculate(h, `{ "taxYear": 2026, "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`); code != http.StatusOK || got.Tax != 29000.0 {
+				t.Fatalf("expected 200 and tax 29000 but got %d and %v", code, got.Tax)
+			}
+		}
+		if loader.loads != 1 {
+			t.Errorf("expected 1 load but got %d", loader.loads)
+		}
+	})
+
+	t.Run("given cached rules should resolve the deduction in force for the tax year", func(t *testing.T) {
+		loader := &StubLoader{rules: Rules{Levels: levels, Deducts: deducts}}
+		h := New(StubTax{}).WithRulesCache(NewRulesCache(loader, time.Hour))
+
+		if _, got := calculate(h, `{ "taxYear": 2027, "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`); got.Tax != 28000.0 {
+			t.Errorf("expected tax 28000 but got %v", got.Tax)
+		}
+	})
+
+	t.Run("given rules invalidated or expired should read the rules again", func(t *testing.T) {
+		loader := &StubLoader{rules: Rules{Levels: levels, Deducts: deducts}}
+		cache := NewRulesCache(loader, time.Hour)
+		h := New(StubTax{}).WithRulesCache(cache)
+
+		calculate(h, `{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`)
+		cache.Invalidate()
+		calculate(h, `{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`)
+		if loader.loads != 2 {
+			t.Errorf("expected 2 loads after invalidate but got %d", loader.loads)
+		}
+
+		cache.ttl = 0
+		calculate(h, `{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`)
+		if loader.loads != 3 {
+			t.Errorf("expected 3 loads after expiry but got %d", loader.loads)
+		}
+	})
+
+	t.Run("given rules unable to load should return 500", func(t *testing.T) {
+		loader := &StubLoader{err: echo.ErrInternalServerError}
+		h := New(StubTax{}).WithRulesCache(NewRulesCache(loader, time.Hour))
+
+		if code, _ := calculate(h, `{ "totalIncome": 500000.0, "wht": 0.0, "allowances": [] }`); code != http.StatusInternalServerError {
+			t.Errorf("expected status code %d but got %d", http.StatusInternalServerError, code)
+		}
+	})
 }
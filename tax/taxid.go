@@ -0,0 +1,30 @@
+package tax
+
+import "strings"
+
+// validTaxID reports whether id is a 13-digit Thai national ID or
+// taxpayer ID with a valid check digit.
+func validTaxID(id string) bool {
+	if len(id) != 13 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 13; i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+		if i < 12 {
+			sum += int(id[i]-'0') * (13 - i)
+		}
+	}
+	return (11-sum%11)%10 == int(id[12]-'0')
+}
+
+// maskTaxID hides all but the first and the last four digits of id.
+func maskTaxID(id string) string {
+	if len(id) <= 5 {
+		return id
+	}
+	return id[:1] + strings.Repeat("x", len(id)-5) + id[len(id)-4:]
+}
@@ -0,0 +1,184 @@
+package tax
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/connapotae/assessment-tax/dberr"
+	"github.com/connapotae/assessment-tax/history"
+	"github.com/connapotae/assessment-tax/i18n"
+	"github.com/gocarina/gocsv"
+	"github.com/labstack/echo/v4"
+)
+
+// incomeTypes are the assessable income categories of section 40 of the
+// Revenue Code that a 50 Tawi certificate can report.
+var incomeTypes = map[string]bool{
+	"40(1)": true,
+	"40(2)": true,
+	"40(3)": true,
+	"40(4)": true,
+	"40(5)": true,
+	"40(6)": true,
+	"40(7)": true,
+	"40(8)": true,
+}
+
+func (w WithholdingCalculations) validate(lang string) []ValidateErr {
+	errs := w.TaxCalcualtions.validate(lang)
+	gtZero := i18n.T(lang, i18n.GtZero)
+
+	if len(w.Certificates) == 0 {
+		errs = append(errs, ValidateErr{
+			Field:   "certificates",
+			Message: i18n.T(lang, i18n.NotEmpty),
+		})
+	}
+
+	for i, v := range w.Certificates {
+		field := fmt.Sprintf("certificates[%d]", i)
+		if !validTaxID(v.PayerTaxID) {
+			errs = append(errs, ValidateErr{
+				Field:   field + ".payerTaxId",
+				Message: i18n.T(lang, i18n.InvalidTaxID),
+			})
+		}
+		if !incomeTypes[v.IncomeType] {
+			errs = append(errs, ValidateErr{
+				Field:   field + ".incomeType",
+				Message: i18n.T(lang, i18n.IncomeTypeNotSupport),
+			})
+		}
+		if v.AmountPaid < 0 {
+			errs = append(errs, ValidateErr{
+				Field:   field + ".amountPaid",
+				Message: gtZero,
+			})
+		}
+		if v.TaxWithheld < 0 {
+			errs = append(errs, ValidateErr{
+				Field:   field + ".taxWithheld",
+				Message: gtZero,
+			})
+		}
+		if v.TaxWithheld > v.AmountPaid {
+			errs = append(errs, ValidateErr{
+				Field:   field + ".taxWithheld",
+				Message: i18n.T(lang, i18n.LtAmountPaid),
+			})
+		}
+	}
+
+	return errs
+}
+
+// fold adds the income and tax withheld of every certificate to the
+// calculation and returns the income grouped by section.
+func (w WithholdingCalculations) fold() (TaxCalcualtions, []IncomeByType) {
+	t := w.TaxCalcualtions
+	t.Allowances = append([]Allowances{}, w.Allowances...)
+
+	var incomes []IncomeByType
+	index := make(map[string]int)
+	for _, v := range w.Certificates {
+		t.TotalIncome += v.AmountPaid
+		t.Wht += v.TaxWithheld
+
+		if i, ok := index[v.IncomeType]; ok {
+			incomes[i].Amount += v.AmountPaid
+			continue
+		}
+		index[v.IncomeType] = len(incomes)
+		incomes = append(incomes, IncomeByType{IncomeType: v.IncomeType, Amount: v.AmountPaid})
+	}
+	return t, incomes
+}
+
+// bindWithholding reads the request either as JSON or as a multipart form
+// with the certificates in a CSV "file" and an optional "calculation"
+// field holding the rest of the request as JSON.
+func bindWithholding(c echo.Context) (WithholdingCalculations, error) {
+	var w WithholdingCalculations
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		err := c.Bind(&w)
+		return w, err
+	}
+
+	if calc := c.FormValue("calculation"); calc != "" {
+		if err := json.Unmarshal([]byte(calc), &w.TaxCalcualtions); err != nil {
+			return w, err
+		}
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return w, err
+	}
+	f, err := file.Open()
+	if err != nil {
+		return w, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return w, err
+	}
+	err = gocsv.UnmarshalBytes(data, &w.Certificates)
+	return w, err
+}
+
+func (h *Handler) WithholdingCalculationsHandler(c echo.Context) error {
+	lang := i18n.Lang(c)
+	w, err := bindWithholding(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Message: i18n.T(lang, i18n.InvalidRequest)})
+	}
+
+	calc, errs, err := h.applyProfile(c.Request().Context(), w.TaxCalcualtions, lang)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+	if len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, errs)
+	}
+	w.TaxCalcualtions = calc
+
+	if err := w.validate(lang); len(err) > 0 {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	t, incomes := w.fold()
+
+	r, err := h.loadRuleset(c.Request().Context(), effectiveDate(t.TaxYear), len(t.ForeignIncomes) > 0)
+	if err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+
+	if err := t.validateCurrency(r.rates, lang); len(err) > 0 {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	res := calculate(t, r, lang)
+	if formatRequested(c) {
+		res = res.withFormat()
+	}
+
+	out := WithholdingTax{
+		Tax:         res,
+		TotalIncome: t.TotalIncome,
+		Wht:         t.Wht,
+		Incomes:     incomes,
+	}
+	if err := h.record(c, history.KindWithholding, w, out, r, w.TaxID); err != nil {
+		code, msg := dberr.Response(c, err)
+		return c.JSON(code, Err{Message: msg})
+	}
+
+	return c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,366 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrFormat is returned for a file that is not a readable workbook.
+	ErrFormat = errors.New("xlsx: not a valid workbook")
+	// ErrSheetNotFound is returned when the workbook has no sheet of the
+	// requested name.
+	ErrSheetNotFound = errors.New("xlsx: sheet not found")
+)
+
+var magic = []byte("PK\x03\x04")
+
+// IsWorkbook reports whether the content starting with head is a zip
+// archive, which is how a workbook is packaged.
+func IsWorkbook(head []byte) bool {
+	return bytes.HasPrefix(head, magic)
+}
+
+// Reader reads the rows of one sheet of a workbook. Only the shared
+// strings of the workbook are held in memory; the rows are decoded as
+// they are read.
+type Reader struct {
+	dec     *xml.Decoder
+	strings []string
+	line    int
+}
+
+// NewReader opens the sheet of the workbook r called sheet, or the first
+// sheet when sheet is empty.
+func NewReader(r io.ReaderAt, size int64, sheet string) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	target, err := sheetPath(files, sheet)
+	if err != nil {
+		return nil, err
+	}
+	f, ok := files[target]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing %s", ErrFormat, target)
+	}
+
+	var shared []string
+	if sf, ok := files["xl/sharedStrings.xml"]; ok {
+		if shared, err = readSharedStrings(sf); err != nil {
+			return nil, err
+		}
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	return &Reader{dec: xml.NewDecoder(rc), strings: shared}, nil
+}
+
+type workbookXML struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		ID   string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type relationshipsXML struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+func decodeFile(f *zip.File, v any) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrFormat, f.Name, err)
+	}
+	return nil
+}
+
+// sheetPath resolves the name of a sheet to the part of the archive that
+// holds its rows.
+func sheetPath(files map[string]*zip.File, sheet string) (string, error) {
+	wf, ok := files["xl/workbook.xml"]
+	if !ok {
+		return "", fmt.Errorf("%w: missing xl/workbook.xml", ErrFormat)
+	}
+	var wb workbookXML
+	if err := decodeFile(wf, &wb); err != nil {
+		return "", err
+	}
+
+	id := ""
+	for _, s := range wb.Sheets {
+		if sheet == "" || strings.EqualFold(s.Name, sheet) {
+			id = s.ID
+			break
+		}
+	}
+	if id == "" {
+		if sheet == "" {
+			return "", fmt.Errorf("%w: no sheets", ErrFormat)
+		}
+		return "", ErrSheetNotFound
+	}
+
+	rf, ok := files["xl/_rels/workbook.xml.rels"]
+	if !ok {
+		return "", fmt.Errorf("%w: missing xl/_rels/workbook.xml.rels", ErrFormat)
+	}
+	var rels relationshipsXML
+	if err := decodeFile(rf, &rels); err != nil {
+		return "", err
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID != id {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+	return "", fmt.Errorf("%w: missing relationship %s", ErrFormat, id)
+}
+
+// readSharedStrings reads the table of strings that cells of type "s"
+// refer to by index. The text of rich text runs is concatenated and
+// phonetic hints are left out.
+func readSharedStrings(f *zip.File) ([]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	defer rc.Close()
+
+	var shared []string
+	dec := xml.NewDecoder(rc)
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return shared, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrFormat, f.Name, err)
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == "si" {
+			s, err := readText(dec, "si")
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrFormat, f.Name, err)
+			}
+			shared = append(shared, s)
+		}
+	}
+}
+
+// readText collects the text of the <t> elements up to the end of the
+// element end.
+func readText(dec *xml.Decoder, end string) (string, error) {
+	var b strings.Builder
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "rPh":
+				if err := dec.Skip(); err != nil {
+					return "", err
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local == "t" {
+				inText = false
+			}
+			if t.Name.Local == end {
+				return b.String(), nil
+			}
+		case xml.CharData:
+			if inText {
+				b.Write(t)
+			}
+		}
+	}
+}
+
+// Read returns the next row that has at least one value. Cells missing
+// from a row are returned as empty strings. It returns io.EOF after the
+// last row.
+func (r *Reader) Read() ([]string, error) {
+	for {
+		tok, err := r.dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "row" {
+				continue
+			}
+			record, err := r.readRow(t)
+			if err != nil {
+				return nil, fmt.Errorf("%w: row %d: %v", ErrFormat, r.line, err)
+			}
+			if !blank(record) {
+				return record, nil
+			}
+		case xml.EndElement:
+			if t.Name.Local == "sheetData" {
+				return nil, io.EOF
+			}
+		}
+	}
+}
+
+// FieldPos returns the row number of the last row read and the 1-based
+// column of field.
+func (r *Reader) FieldPos(field int) (line, column int) {
+	return r.line, field + 1
+}
+
+func (r *Reader) readRow(start xml.StartElement) ([]string, error) {
+	r.line++
+	if n, err := strconv.Atoi(attr(start, "r")); err == nil {
+		r.line = n
+	}
+
+	var record []string
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+			i := len(record)
+			if ref := attr(t, "r"); ref != "" {
+				if i, err = columnIndex(ref); err != nil {
+					return nil, err
+				}
+			}
+			v, err := r.readCell(t)
+			if err != nil {
+				return nil, err
+			}
+			for len(record) <= i {
+				record = append(record, "")
+			}
+			record[i] = v
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				return record, nil
+			}
+		}
+	}
+}
+
+func (r *Reader) readCell(start xml.StartElement) (string, error) {
+	typ := attr(start, "t")
+	var v string
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return "", err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "v":
+				if err := r.dec.DecodeElement(&v, &t); err != nil {
+					return "", err
+				}
+			case "is":
+				if v, err = readText(r.dec, "is"); err != nil {
+					return "", err
+				}
+			default:
+				if err := r.dec.Skip(); err != nil {
+					return "", err
+				}
+			}
+		case xml.EndElement:
+			if t.Name.Local != "c" {
+				continue
+			}
+			if typ != "s" {
+				return v, nil
+			}
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 0 || i >= len(r.strings) {
+				return "", fmt.Errorf("invalid shared string %q", v)
+			}
+			return r.strings[i], nil
+		}
+	}
+}
+
+func attr(se xml.StartElement, name string) string {
+	for _, a := range se.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// maxColumns is the number of columns of a worksheet, up to column XFD.
+const maxColumns int = 16384
+
+// columnIndex returns the zero-based column of a cell reference such as
+// "AB12". References past the last column of a worksheet are rejected, so
+// that a crafted file cannot make a row of any length.
+func columnIndex(ref string) (int, error) {
+	i := 0
+	n := 0
+	for ; n < len(ref) && ref[n] >= 'A' && ref[n] <= 'Z'; n++ {
+		i = i*26 + int(ref[n]-'A'+1)
+		if i > maxColumns {
+			return 0, fmt.Errorf("cell reference %q is past column %s", ref, ColumnName(maxColumns-1))
+		}
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("invalid cell reference %q", ref)
+	}
+	return i - 1, nil
+}
+
+func blank(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
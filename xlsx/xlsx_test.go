@@ -0,0 +1,171 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestColumnName(t *testing.T) {
+	tests := map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"}
+	for in, want := range tests {
+		if got := ColumnName(in); got != want {
+			t.Errorf("ColumnName(%d) expected %s but got %s", in, want, got)
+		}
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    int
+		wantErr bool
+	}{
+		{ref: "A1", want: 0},
+		{ref: "AB12", want: 27},
+		{ref: "XFD1", want: 16383},
+		{ref: "XFE1", wantErr: true},
+		{ref: "ZZZZZZ1", wantErr: true},
+		{ref: "ZZZZZZZZZZZZZZ1", wantErr: true},
+		{ref: "12", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := columnIndex(tt.ref)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("columnIndex(%q) expected %d (error %v) but got %d %v", tt.ref, tt.want, tt.wantErr, got, err)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "taxes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]any{"totalIncome", "name"})
+	w.Write([]any{500000.0, "A & B"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range zr.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		r, _ := f.Open()
+		sheet, _ := io.ReadAll(r)
+		want := `<row r="2"><c r="A2"><v>500000</v></c><c r="B2" t="inlineStr"><is><t xml:space="preserve">A &amp; B</t></is></c></row>`
+		if !strings.Contains(string(sheet), want) {
+			t.Errorf("expected sheet to contain %s but got %s", want, sheet)
+		}
+		return
+	}
+	t.Errorf("expected a worksheet in the workbook")
+}
+
+func TestReaderRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, "taxes")
+	w.Write([]any{"totalIncome", "wht", "name"})
+	w.Write([]any{500000.0, nil, "A & B"})
+	w.Write([]any{})
+	w.Write([]any{600000.0, 40000.0, "C"})
+	w.Close()
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"totalIncome", "wht", "name"},
+		{"500000", "", "A & B"},
+		{"600000", "40000", "C"},
+	}
+	lines := []int{1, 2, 4}
+	for i, w := range want {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "|") != strings.Join(w, "|") {
+			t.Errorf("expected row %v but got %v", w, got)
+		}
+		if line, _ := r.FieldPos(0); line != lines[i] {
+			t.Errorf("expected line %d but got %d", lines[i], line)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("expected io.EOF but got %v", err)
+	}
+}
+
+func TestReaderSharedStringsAndNamedSheet(t *testing.T) {
+	parts := map[string]string{
+		"xl/workbook.xml":            `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Summary" sheetId="1" r:id="rId1"/><sheet name="Payroll" sheetId="2" r:id="rId2"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Target="worksheets/sheet1.xml"/><Relationship Id="rId2" Target="/xl/worksheets/sheet2.xml"/></Relationships>`,
+		"xl/sharedStrings.xml":       `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><si><t>totalIncome</t></si><si><r><t>dona</t></r><r><t>tion</t></r><rPh><t>x</t></rPh></si></sst>`,
+		"xl/worksheets/sheet1.xml":   `<worksheet><sheetData><row r="1"><c r="A1"><v>1</v></c></row></sheetData></worksheet>`,
+		"xl/worksheets/sheet2.xml":   `<worksheet><sheetData><row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row><row r="2"><c r="A2"><v>500000</v></c><c r="C2"><f>SUM(1,2)</f><v>3</v></c></row></sheetData></worksheet>`,
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range parts {
+		f, _ := zw.Create(name)
+		io.WriteString(f, content)
+	}
+	zw.Close()
+
+	if _, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "missing"); err != ErrSheetNotFound {
+		t.Errorf("expected ErrSheetNotFound but got %v", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "payroll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _ := r.Read()
+	row, _ := r.Read()
+	if got := strings.Join(header, "|") + "/" + strings.Join(row, "|"); got != "totalIncome||donation/500000||3" {
+		t.Errorf("expected header and row of the named sheet but got %s", got)
+	}
+}
+
+func TestReaderCellPastLastColumn(t *testing.T) {
+	refs := []string{"ZZZZZZ1", "ZZZZZZZZZZZZZZ1"}
+	for _, ref := range refs {
+		parts := map[string]string{
+			"xl/workbook.xml":            `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Sheet1" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+			"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+			"xl/worksheets/sheet1.xml":   `<worksheet><sheetData><row r="1"><c r="` + ref + `"><v>1</v></c></row></sheetData></worksheet>`,
+		}
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for name, content := range parts {
+			f, _ := zw.Create(name)
+			io.WriteString(f, content)
+		}
+		zw.Close()
+
+		r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Read(); !errors.Is(err, ErrFormat) {
+			t.Errorf("expected ErrFormat for cell %s but got %v", ref, err)
+		}
+	}
+}
+
+func TestIsWorkbook(t *testing.T) {
+	if !IsWorkbook([]byte("PK\x03\x04rest")) || IsWorkbook([]byte("totalIncome")) {
+		t.Errorf("expected only zip content to be a workbook")
+	}
+}